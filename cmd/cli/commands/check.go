@@ -8,11 +8,14 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules/remote"
 	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
 )
 
 const (
-	usageResourceGroup = "Specifies resource group"
+	usageResourceGroup    = "Specifies resource group"
+	usageCheckMappingFile = "Location of the tag rules definition, resources matching the rules are reported as non-compliant"
 )
 
 var (
@@ -24,18 +27,54 @@ func init() {
 	rootCmd.AddCommand(checkCommand)
 	checkCommand.Flags().StringVarP(&resourceGroup, "rg", "r", "", usageResourceGroup)
 	checkCommand.MarkFlagRequired("rg")
+	checkCommand.Flags().StringVarP(&mappingFile, "map", "m", "", usageCheckMappingFile)
+	checkCommand.Flags().StringVar(&groupBy, "group-by", "", usageGroupBy)
+	addRulesFlags(checkCommand)
+	addNotifyFlags(checkCommand)
+	addMailFlags(checkCommand)
+	addSubscriptionFlags(checkCommand)
+	addScannerFlag(checkCommand)
+	addSnapshotFlags(checkCommand)
 }
 
 var checkCommand = &cobra.Command{
 	Use:   "check",
 	Short: "Do sanity checks on a resource group (NOT FULLY IMPLEMENTED YET)",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sess, err := session.NewFromFile()
-		if err != nil {
-			return errors.Wrap(err, "could not create session")
+		if err := checkGroupBy(); err != nil {
+			return err
+		}
+		if err := checkNotify(); err != nil {
+			return err
+		}
+		if err := checkMail(); err != nil {
+			return err
+		}
+		if err := checkScanner(); err != nil {
+			return err
 		}
 
-		scanner := azure.NewResourceGroupScanner(sess)
+		var (
+			t      *rules.TagRules
+			source *remote.Source
+		)
+		if mappingFile != "" {
+			loaded, loadedFrom, err := loadRules()
+			if err != nil {
+				return err
+			}
+			if printRendered {
+				return printRules(loaded)
+			}
+			source = loadedFrom
+			fmt.Printf("Rules loaded from: %s\n", source)
+			t = &loaded
+		}
+
+		sess, scanner, err := openScanner(t)
+		if err != nil {
+			return err
+		}
 		res, err := scanner.GetResourcesByResourceGroup(resourceGroup)
 		if err != nil {
 			return errors.Wrap(err, "could not get resources by group")
@@ -57,5 +96,35 @@ var checkCommand = &cobra.Command{
 			fmt.Printf("💪  Resource group [%s] has no tags with different values\n", resourceGroup)
 		}
 
+		if t != nil {
+			return checkRules(*t, source, sess, res)
+		}
+
 		return nil
 	}}
+
+// checkRules evaluates the rules in audit mode and reports matching resources
+func checkRules(t rules.TagRules, source *remote.Source, sess *session.AzureSession, res []azure.Resource) error {
+	for i, rule := range t.Rules {
+		if rule.GetMode() != rules.ModeDisabled {
+			t.Rules[i].Mode = rules.ModeAudit
+		}
+	}
+
+	tagger := azure.NewTagger(t, sess)
+	tagger.DryRun()
+	tagger.EvaluateRules(res)
+
+	fmt.Printf("\nChecking rules from [%s] in [%s]\n", mappingFile, resourceGroup)
+	if len(tagger.Matched) == 0 {
+		fmt.Printf("💪  Resource group [%s] is compliant with the rules\n", resourceGroup)
+		return notifyRun("check", true, source, azure.Summarize(nil))
+	}
+
+	ael, err := tagger.ExecuteActions()
+	if err != nil {
+		return errors.Wrap(err, "can't evaluate rules")
+	}
+	printExecutions(ael)
+	return mailAndNotify("check", true, source, azure.Summarize(ael), ael)
+}
@@ -0,0 +1,168 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules/remote"
+	"github.com/nordcloud/azure-tag-manager/internal/mail"
+)
+
+const (
+	usageOwnerTag     = "Tag of resources holding their owner, who gets a digest of the results on them"
+	usageMailDomain   = "Domain appended to owners which are not email addresses"
+	usageMailFallback = "Address getting the digest of the resources without an owner"
+	usageMailFrom     = "Sender of the digests"
+	usageMailSubject  = "Template of the subject of the digests"
+	usageMailTemplate = "File with the template of the body of the digests"
+	usageSMTPServer   = "SMTP server (host:port) the digests are sent with, using STARTTLS"
+	usageSMTPUser     = "User authenticating to the SMTP server"
+	usageSMTPPassword = "Password of the SMTP user (default $TAGMANAGER_SMTP_PASSWORD)"
+	usageToDir        = "Write the digests to .eml files in this directory instead of sending them"
+)
+
+var (
+	ownerTag     string
+	mailDomain   string
+	mailFallback string
+	mailFrom     string
+	mailSubject  string
+	mailTemplate string
+	smtpServer   string
+	smtpUser     string
+	smtpPassword string
+	toDir        string
+
+	digestRenderer *mail.Renderer // set by checkMail if digests are enabled
+)
+
+// addMailFlags adds the flags of the owner digests to cmd
+func addMailFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&ownerTag, "owner-tag", "owner", usageOwnerTag)
+	cmd.Flags().StringVar(&mailDomain, "mail-domain", "", usageMailDomain)
+	cmd.Flags().StringVar(&mailFallback, "mail-fallback", "", usageMailFallback)
+	cmd.Flags().StringVar(&mailFrom, "mail-from", "", usageMailFrom)
+	cmd.Flags().StringVar(&mailSubject, "mail-subject", mail.DefaultSubject, usageMailSubject)
+	cmd.Flags().StringVar(&mailTemplate, "mail-template", "", usageMailTemplate)
+	cmd.Flags().StringVar(&smtpServer, "smtp-server", "", usageSMTPServer)
+	cmd.Flags().StringVar(&smtpUser, "smtp-user", "", usageSMTPUser)
+	cmd.Flags().StringVar(&smtpPassword, "smtp-password", "", usageSMTPPassword)
+	cmd.Flags().StringVar(&toDir, "to-dir", "", usageToDir)
+}
+
+// checkMail validates the flags of the owner digests and parses their templates
+func checkMail() error {
+	if smtpServer == "" && toDir == "" {
+		return nil
+	}
+	if smtpServer != "" && toDir != "" {
+		return errors.New("use either --smtp-server or --to-dir")
+	}
+	if mailFrom == "" {
+		return errors.New("--mail-from is required to send digests")
+	}
+	if _, err := mail.Address(mailFrom, ""); err != nil {
+		return errors.Wrap(err, "invalid --mail-from")
+	}
+
+	body := mail.DefaultBody
+	if mailTemplate != "" {
+		dat, err := ioutil.ReadFile(mailTemplate)
+		if err != nil {
+			return errors.Wrap(err, "can't read the mail template")
+		}
+		body = string(dat)
+	}
+	renderer, err := mail.NewRenderer(mailSubject, body)
+	if err != nil {
+		return err
+	}
+	digestRenderer = renderer
+	return nil
+}
+
+// mailDigests sends a digest of ael to the owner of each resource, or writes them to --to-dir
+func mailDigests(command string, dryRun bool, ael []azure.ActionExecution) error {
+	if digestRenderer == nil || len(ael) == 0 {
+		return nil
+	}
+
+	// owners with the same address, like "ann" with --mail-domain and "ann@example.com", get a single digest
+	var (
+		addrs   []string
+		digests = make(map[string]*mail.Digest)
+	)
+	for _, digest := range mail.NewDigests(ael, ownerTag, command, dryRun) {
+		owner := digest.Owner
+		if owner == "" {
+			owner = mailFallback
+		}
+		if owner == "" {
+			log.Warnf("No digest for %d resource(s) without the %s tag, use --mail-fallback", len(digest.Resources), ownerTag)
+			continue
+		}
+		to, err := mail.Address(owner, mailDomain)
+		if err != nil {
+			log.Warnf("Can't send the digest of %d resource(s) owned by [%s]: %s", len(digest.Resources), owner, err)
+			continue
+		}
+		if merged, ok := digests[strings.ToLower(to)]; ok {
+			merged.Merge(digest)
+			continue
+		}
+		digest := digest
+		digests[strings.ToLower(to)] = &digest
+		addrs = append(addrs, to)
+	}
+
+	var msgs []mail.Message
+	for _, to := range addrs {
+		msg, err := digestRenderer.Render(*digests[strings.ToLower(to)], mailFrom, to)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if toDir != "" {
+		paths, err := mail.WriteDir(toDir, msgs...)
+		for _, path := range paths {
+			fmt.Printf("Digest written to: %s\n", path)
+		}
+		return err
+	}
+
+	password := smtpPassword
+	if password == "" {
+		password = os.Getenv("TAGMANAGER_SMTP_PASSWORD")
+	}
+	mailer := &mail.Mailer{Addr: smtpServer, Username: smtpUser, Password: password}
+	if err := mailer.Send(msgs...); err != nil {
+		return errors.Wrap(err, "can't send the digests")
+	}
+	fmt.Printf("Digests sent to %d owner(s)\n", len(msgs))
+	return nil
+}
+
+// mailAndNotify sends the digests of ael and posts the summary of the run. The summary is posted even if the
+// digests can't be sent
+func mailAndNotify(command string, dryRun bool, source *remote.Source, summary azure.Summary, ael []azure.ActionExecution) error {
+	mailErr := mailDigests(command, dryRun, ael)
+	if err := notifyRun(command, dryRun, source, summary); err != nil {
+		if mailErr != nil {
+			log.Error(mailErr)
+		}
+		return err
+	}
+	return mailErr
+}
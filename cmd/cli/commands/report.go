@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+const (
+	usageGroupBy = "Group the report by rule metadata: rule, owner, ticket, severity, mode or label:<name>"
+)
+
+var (
+	groupBy string
+)
+
+// checkGroupBy validates the value of the --group-by flag
+func checkGroupBy() error {
+	if groupBy != "" && !rules.IsMetadataKey(groupBy) {
+		return errors.Errorf("can't group by %q, use one of rule, owner, ticket, severity, mode or label:<name>", groupBy)
+	}
+	return nil
+}
+
+// printExecutions prints the executed actions together with the metadata of their rules, grouped by --group-by
+func printExecutions(ael []azure.ActionExecution) {
+	if groupBy == "" {
+		for _, ae := range ael {
+			printExecution(ae)
+		}
+		return
+	}
+
+	names, groups := azure.GroupExecutions(ael, groupBy)
+	for _, name := range names {
+		fmt.Printf("\n[%s: %s] %d rule execution(s)\n", groupBy, name, len(groups[name]))
+		for _, ae := range groups[name] {
+			printExecution(ae)
+		}
+	}
+}
+
+func printExecution(ae azure.ActionExecution) {
+	if ae.NonCompliant() {
+		fmt.Printf("Rule [%s] (audit) reports [%s] as non-compliant\n", ae.RuleName, ae.ResourceID)
+	} else {
+		fmt.Printf("Rule [%s] on [%s]\n", ae.RuleName, ae.ResourceID)
+	}
+	if ae.Subscription != "" {
+		fmt.Printf("Subscription: [%s]\n", subscriptionLabel(ae.Subscription))
+	}
+	if ae.Rule.Description != "" {
+		fmt.Printf("Description: %s\n", ae.Rule.Description)
+	}
+	if metadata := ae.Rule.MetadataString(); metadata != "" {
+		fmt.Printf("Metadata: [%s]\n", metadata)
+	}
+	if ae.NonCompliant() {
+		return
+	}
+	for _, action := range ae.Actions {
+		printAction(action)
+	}
+	for _, output := range ae.Outputs {
+		fmt.Printf("Output of [%s]:\n%s\n", output.Type, output.Output)
+	}
+}
+
+// printAction prints the type and the parameters of action, tag actions in the tag = value form
+func printAction(action rules.ActionItem) {
+	if _, ok := action["tag"]; ok {
+		fmt.Printf("Action: [%s] [%s = %s]\n", action.GetType(), action.GetString("tag"), action.GetString("value"))
+		return
+	}
+
+	var params []string
+	for key := range action {
+		if key != "type" {
+			params = append(params, key)
+		}
+	}
+	if len(params) == 0 {
+		fmt.Printf("Action: [%s]\n", action.GetType())
+		return
+	}
+	sort.Strings(params)
+	for i, key := range params {
+		value := action.GetString(key)
+		switch action[key].(type) {
+		case []interface{}, []string:
+			value = strings.Join(action.GetStrings(key), ", ")
+		case map[string]interface{}, map[string]string:
+			var pairs []string
+			for k, v := range action.GetMap(key) {
+				pairs = append(pairs, k+" = "+v)
+			}
+			sort.Strings(pairs)
+			value = strings.Join(pairs, ", ")
+		}
+		if strings.Contains(value, "\n") {
+			value = fmt.Sprintf("(%d lines)", strings.Count(strings.TrimSpace(value), "\n")+1)
+		}
+		params[i] = key + ": " + value
+	}
+	fmt.Printf("Action: [%s] [%s]\n", action.GetType(), strings.Join(params, "; "))
+}
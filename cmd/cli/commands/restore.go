@@ -7,21 +7,25 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules/remote"
 	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
 )
 
 const (
-	usageRestoreFile = "Specify the location of the restore file"
+	usageRestoreFile       = "Specify the location of the restore file"
+	usageRestoreIncomplete = "Restore the entries of a backup whose run was interrupted"
 )
 
 var (
-	restoreFile string
+	restoreFile       string
+	restoreIncomplete bool
 )
 
 func init() {
 	rootCmd.AddCommand(restoreCommand)
 	restoreCommand.Flags().StringVarP(&restoreFile, "file", "f", "", usageRestoreFile)
 	restoreCommand.MarkFlagRequired("file")
+	restoreCommand.Flags().BoolVar(&restoreIncomplete, "incomplete", false, usageRestoreIncomplete)
 }
 
 var restoreCommand = &cobra.Command{
@@ -36,6 +40,16 @@ var restoreCommand = &cobra.Command{
 		fmt.Printf("Restoring tags from: [%s]\n", restoreFile)
 
 		restorer := azure.NewRestorerFromFile(restoreFile, sess)
+		if restorer.Incomplete && !restoreIncomplete {
+			return errors.Errorf("backup %s is incomplete, pass --incomplete to restore its %d entries", restoreFile, len(restorer.Backup))
+		}
+		if md := restorer.Metadata; !md.CreatedAt.IsZero() {
+			fmt.Printf("Backup made by [%s] at [%s]\n", md.Command, md.CreatedAt)
+			if md.RulesSource != "" {
+				source := remote.Source{Location: md.RulesSource, Revision: md.RulesRevision, Digest: md.RulesDigest}
+				fmt.Printf("Rules were loaded from: %s\n", source)
+			}
+		}
 		err = restorer.Restore()
 
 		if err != nil {
@@ -18,6 +18,9 @@ func init() {
 	resourceGroupTagCommand.Flags().BoolVar(&cleanTags, "cleantags", false, "Clean all tags before adding")
 	resourceGroupTagCommand.MarkFlagRequired("rg")
 	resourceGroupTagCommand.Flags().BoolVar(&dryRunEnabled, "dry", false, usageDryRun)
+	addNotifyFlags(resourceGroupTagCommand)
+	addSubscriptionFlags(resourceGroupTagCommand)
+	addScopeFlags(resourceGroupTagCommand)
 
 }
 
@@ -26,76 +29,101 @@ var resourceGroupTagCommand = &cobra.Command{
 	Short: "Retag resources in a rg based on tags on rgs",
 	Long:  "Takes tags form a given resource group and applies them to all of the resources in the resource group. If any existing tags are already there, the new ones with be appended.",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkNotify(); err != nil {
+			return err
+		}
 
 		sess, err := session.NewFromFile()
 		if err != nil {
 			return errors.Wrap(err, "Could not create session")
 		}
-		scanner := azure.NewResourceGroupScanner(sess)
-
-		rgTags, err := scanner.GetResourceGroupTags(resourceGroup)
-
+		subs, err := selectSubscriptions(sess)
 		if err != nil {
-			return errors.Wrap(err, "Can't get tags")
+			return err
 		}
 
-		resources := scanner.ScanResourceGroup(resourceGroup)
+		// the resource group of each subscription has its own tags, so each subscription has its own tagger
+		var taggers []*azure.Tagger
+		matched := make(map[string]azure.Matched)
+		for _, scanner := range azure.NewSubscriptionsScanner(sess, subs).Scanners {
+			scanner.Scope = scanScope(nil)
+			rgTags, err := scanner.GetResourceGroupTags(resourceGroup)
+			if azure.IsNotFound(err) && len(subs) > 1 {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, "Can't get tags")
+			}
 
-		var actions []rules.ActionItem
+			resources, err := scanner.GetResourcesByResourceGroup(resourceGroup)
+			if err != nil {
+				return errors.Wrap(err, "Can't get resources")
+			}
 
-		if cleanTags {
-			actions = append(actions, rules.ActionItem{"type": "cleanTags"})
+			tagger := azure.NewTagger(retagRules(rgTags), sess)
+			if dryRunEnabled {
+				tagger.DryRun()
+			}
+			tagger.EvaluateRules(resources)
+			for id, m := range tagger.Matched {
+				matched[id] = m
+			}
+			taggers = append(taggers, tagger)
 		}
-
-		for key, tag := range rgTags {
-			actions = append(actions, rules.ActionItem{"type": "addTag", "tag": key, "value": *tag})
+		if len(taggers) == 0 {
+			return errors.Errorf("resource group %s not found", resourceGroup)
 		}
-
-		rules := rules.TagRules{Rules: []rules.Rule{
-			{Name: "name", Conditions: []rules.ConditionItem{
-				{"type": "rgEqual", "resourceGroup": resourceGroup},
-			},
-				Actions: actions,
-			},
-		}}
-
-		tagger := azure.NewTagger(rules, sess)
 		if dryRunEnabled {
-			tagger.DryRun()
 			fmt.Println("!! Running in a dry run mode")
 			fmt.Println("!! No actions will be executed")
 		}
 
-		tagger.EvaluateRules(resources)
-
 		fmt.Println("Evaluating conditions")
-		for _, i := range tagger.Matched {
+		for _, i := range matched {
 			r := i.Resource
-			fmt.Printf("Conditions of [%d] rule(s) matched for [%s] in [%s] with ID %s\n", len(i.TagRules), *r.Name, *r.ResourceGroup, r.ID)
+			fmt.Printf("Conditions of [%d] rule(s) matched for [%s] in [%s] of subscription [%s] with ID %s\n", len(i.TagRules), *r.Name, *r.ResourceGroup, subscriptionLabel(r.SubscriptionID()), r.ID)
 		}
 
-		if len(tagger.Matched) > 0 {
+		if len(matched) > 0 {
 			fmt.Println("\nExecuting actions on matched resources")
-			backupFile := azure.NewBackupFromMatched(tagger.Matched, "")
+			backupFile := azure.NewBackupFromMatched(matched, "", backupMetadata("retagrg", nil))
 			fmt.Printf("Backup will be saved in: %s\n", backupFile)
 
-			ael, err := tagger.ExecuteActions()
-
-			if err != nil {
-				return errors.Wrap(err, "can't execute actions")
-			}
-			fmt.Println("Executing actions")
-			for _, ae := range ael {
-				fmt.Printf("Rule [%s] on [%s]\n", ae.RuleName, ae.ResourceID)
-				for _, action := range ae.Actions {
-					fmt.Printf("Action: [%s] [%s = %s]\n", action.GetType(), action["tag"], action["value"])
+			var ael []azure.ActionExecution
+			for _, tagger := range taggers {
+				executed, err := tagger.ExecuteActions()
+				if err != nil {
+					return errors.Wrap(err, "can't execute actions")
 				}
+				ael = append(ael, executed...)
 			}
-
-		} else {
-			fmt.Println("No resources matched your conditions 😫")
+			fmt.Println("Executing actions")
+			printExecutions(ael)
+			return notifyRun("retagrg", dryRunEnabled, nil, azure.Summarize(ael))
 		}
 
-		return nil
+		fmt.Println("No resources matched your conditions 😫")
+		return notifyRun("retagrg", dryRunEnabled, nil, azure.Summarize(nil))
 	},
 }
+
+// retagRules returns the rules adding the tags of a resource group to its resources
+func retagRules(rgTags map[string]*string) rules.TagRules {
+	var actions []rules.ActionItem
+
+	if cleanTags {
+		actions = append(actions, rules.ActionItem{"type": "cleanTags"})
+	}
+
+	for key, tag := range rgTags {
+		actions = append(actions, rules.ActionItem{"type": "addTag", "tag": key, "value": *tag})
+	}
+
+	return rules.TagRules{Rules: []rules.Rule{
+		{Name: "name", Conditions: []rules.ConditionItem{
+			{"type": "rgEqual", "resourceGroup": resourceGroup},
+		},
+			Actions: actions,
+		},
+	}}
+}
@@ -1,11 +1,11 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/nordcloud/azure-tag-manager/internal/azure"
-	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
-	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules/remote"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -13,11 +13,13 @@ import (
 const (
 	usageMappingFile = "Location of the tag rules definition (json)"
 	usageDryRun      = "The tagger will not execute any actions"
+	usageStream      = "Execute the actions of each matched resource as soon as it is scanned, instead of after the whole scan"
 )
 
 var (
 	mappingFile   string
 	dryRunEnabled bool
+	streamEnabled bool
 )
 
 func init() {
@@ -25,34 +27,67 @@ func init() {
 	rewriteCommand.Flags().StringVarP(&mappingFile, "map", "m", "", usageMappingFile)
 	rewriteCommand.MarkFlagRequired("map")
 	rewriteCommand.Flags().BoolVar(&dryRunEnabled, "dry", false, usageDryRun)
+	rewriteCommand.Flags().BoolVar(&streamEnabled, "stream", false, usageStream)
+	rewriteCommand.Flags().StringVar(&groupBy, "group-by", "", usageGroupBy)
+	addRulesFlags(rewriteCommand)
+	rewriteCommand.Flags().BoolVar(&allowExec, "allow-exec", false, usageAllowExec)
+	addNotifyFlags(rewriteCommand)
+	addMailFlags(rewriteCommand)
+	addSubscriptionFlags(rewriteCommand)
+	addScannerFlag(rewriteCommand)
+	addSnapshotFlags(rewriteCommand)
 }
 
 var rewriteCommand = &cobra.Command{
 	Use:   "rewrite",
 	Short: "Rewrite tags based on rules from a file",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		t, err := rules.NewFromFile(mappingFile)
+		if err := checkGroupBy(); err != nil {
+			return err
+		}
+		if err := checkNotify(); err != nil {
+			return err
+		}
+		if err := checkMail(); err != nil {
+			return err
+		}
+		if err := checkScanner(); err != nil {
+			return err
+		}
+
+		t, source, err := loadRules()
 		if err != nil {
-			return errors.Wrapf(err, "Can't parse rules from %s", mappingFile)
+			return err
+		}
+		if printRendered {
+			return printRules(t)
 		}
+		if err := checkExec(t, source); err != nil {
+			return err
+		}
+		fmt.Printf("Rules loaded from: %s\n", source)
 
-		sess, err := session.NewFromFile()
+		sess, scanner, err := openScanner(&t)
 		if err != nil {
-			return errors.Wrap(err, "Could not create session")
+			return err
 		}
 
 		tagger := azure.NewTagger(t, sess)
-		if dryRunEnabled {
+		if dryRunEnabled || offline() {
 			tagger.DryRun()
+		}
+		if tagger.IsDryRun() {
 			fmt.Println("!! Running in a dry run mode")
 			fmt.Println("!! No actions will be executed")
 		}
 
-		if err != nil {
-			return errors.Wrap(err, "Can't create tagger")
+		if streamEnabled {
+			streamer, ok := scanner.(azure.ResourceStreamer)
+			if !ok {
+				return errors.New("the scanner can't stream resources")
+			}
+			return rewriteStream(tagger, streamer, source)
 		}
-
-		scanner := azure.NewResourceGroupScanner(tagger.Session)
 		res, err := scanner.GetResources()
 		if err != nil {
 			return errors.Wrap(err, "can't scan resources")
@@ -63,12 +98,12 @@ var rewriteCommand = &cobra.Command{
 		fmt.Println("Evaluating conditions")
 		for _, i := range tagger.Matched {
 			r := i.Resource
-			fmt.Printf("Conditions of [%d] rule(s) matched for [%s] in [%s] with ID %s\n", len(i.TagRules), *r.Name, *r.ResourceGroup, r.ID)
+			fmt.Printf("Conditions of [%d] rule(s) matched for [%s] in [%s] of subscription [%s] with ID %s\n", len(i.TagRules), *r.Name, *r.ResourceGroup, subscriptionLabel(r.SubscriptionID()), r.ID)
 		}
 
 		if len(tagger.Matched) > 0 {
 			fmt.Println("\nExecuting actions on matched resources")
-			backupFile := azure.NewBackupFromMatched(tagger.Matched, "")
+			backupFile := azure.NewBackupFromMatched(tagger.Matched, "", backupMetadata("rewrite", source))
 			fmt.Printf("Backup will be saved in: %s\n", backupFile)
 
 			ael, err := tagger.ExecuteActions()
@@ -77,16 +112,58 @@ var rewriteCommand = &cobra.Command{
 				return errors.Wrap(err, "can't exec actions")
 			}
 			fmt.Println("Executing actions")
-			for _, ae := range ael {
-				fmt.Printf("Rule [%s] on [%s]\n", ae.RuleName, ae.ResourceID)
-				for _, action := range ae.Actions {
-					fmt.Printf("Action: [%s] [%s = %s]\n", action.GetType(), action["tag"], action["value"])
-				}
-			}
+			printExecutions(ael)
+			return mailAndNotify("rewrite", tagger.IsDryRun(), source, azure.Summarize(ael), ael)
+		}
+
+		fmt.Println("No resources matched your conditions 😫")
+		return notifyRun("rewrite", tagger.IsDryRun(), source, azure.Summarize(nil))
+	},
+}
 
-		} else {
-			fmt.Println("No resources matched your conditions 😫")
+// rewriteStream evaluates the rules and executes the actions on the resources as they are scanned. Each matched
+// resource is added to the backup before its actions are executed, and its executions are printed after. They are
+// only kept if owner digests are sent
+func rewriteStream(tagger *azure.Tagger, streamer azure.ResourceStreamer, source *remote.Source) error {
+	fmt.Println("Evaluating conditions and executing actions as resources are scanned")
+	var (
+		backup *azure.BackupWriter
+		ael    []azure.ActionExecution
+	)
+	summary, err := tagger.Pipeline(context.Background(), streamer, func(m azure.Matched) error {
+		if backup == nil {
+			var err error
+			backup, err = azure.NewBackupWriter("", backupMetadata("rewrite", source))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Backup will be saved in: %s\n", backup.Name())
+		}
+		r := m.Resource
+		fmt.Printf("Conditions of [%d] rule(s) matched for [%s] in [%s] of subscription [%s] with ID %s\n", len(m.TagRules), *r.Name, *r.ResourceGroup, subscriptionLabel(r.SubscriptionID()), r.ID)
+		return backup.Add(m)
+	}, func(executions []azure.ActionExecution) error {
+		for _, ae := range executions {
+			printExecution(ae)
+		}
+		if digestRenderer != nil {
+			ael = append(ael, executions...)
 		}
 		return nil
-	},
+	})
+	if backup != nil {
+		if cerr := backup.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "can't exec actions")
+	}
+
+	if summary.Resources == 0 {
+		fmt.Println("No resources matched your conditions 😫")
+		return notifyRun("rewrite", tagger.IsDryRun(), source, summary)
+	}
+	fmt.Printf("Executed %d rule execution(s) on %d resource(s)\n", summary.Executions, summary.Resources)
+	return mailAndNotify("rewrite", tagger.IsDryRun(), source, summary, ael)
 }
@@ -3,29 +3,65 @@ package commands
 import (
 	"fmt"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/plugin"
+)
+
+const (
+	usagePluginDir     = "Directory with plugin executables adding conditions and actions"
+	usagePluginTimeout = "Time a plugin has to answer a request"
 )
 
-var verbose bool
+// Version is the version of tagmanager, recorded in snapshots
+var Version = "dev"
+
+var (
+	verbose       bool
+	pluginDir     string
+	pluginTimeout time.Duration
+	plugins       []*plugin.Plugin
+)
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().StringVar(&pluginDir, "plugin-dir", os.Getenv("TAGMANAGER_PLUGIN_DIR"), usagePluginDir)
+	rootCmd.PersistentFlags().DurationVar(&pluginTimeout, "plugin-timeout", plugin.DefaultTimeout, usagePluginTimeout)
 }
 
 var rootCmd = &cobra.Command{
 	Use: "tagmanager",
-	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		if verbose {
 			log.SetLevel(log.InfoLevel)
 		}
+		return nil
 	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		plugin.CloseAll(plugins)
+	},
+}
+
+// loadPlugins starts the plugins of --plugin-dir, once. Only the commands using conditions and actions load them
+func loadPlugins() error {
+	if pluginDir == "" || plugins != nil {
+		return nil
+	}
+	loaded, err := plugin.Load(pluginDir, pluginTimeout)
+	if err != nil {
+		return err
+	}
+	plugins = loaded
+	return nil
 }
 
 // Execute handles command
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		plugin.CloseAll(plugins)
 		fmt.Println(err)
 		os.Exit(1)
 	}
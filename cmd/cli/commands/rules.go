@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules/remote"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+const (
+	usagePublicKey     = "Location of an ed25519 public key (PEM) verifying signatures of rules files, which all must be signed, can be repeated"
+	usageDigest        = "Expected sha256 of a rules file, in hex. Every loaded file, including the included ones, must have one of the digests, can be repeated"
+	usageRequireSigned = "Refuse rules files without a signature verified by --public-key, implied by --public-key"
+	usageCacheDir      = "Directory where rules fetched from URLs and git repositories are cached"
+	usageOffline       = "Use the cached copy of rules that can't be fetched from their URL, instead of failing"
+	usageSet           = "Set a parameter substituted for ${key} in the rules file (key=value), can be repeated"
+	usageAllowExec     = "Allow the exec action of the rules to run commands"
+	usagePrintRendered = "Print the rules that will be evaluated, after includes and parameters are resolved, and exit"
+)
+
+var (
+	ruleParams    []string
+	printRendered bool
+	cacheDir      string
+	offlineRules  bool
+	publicKeys    []string
+	rulesDigests  []string
+	requireSigned bool
+	allowExec     bool
+)
+
+// addRulesFlags adds the flags controlling how the rules file is loaded to cmd
+func addRulesFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&ruleParams, "set", nil, usageSet)
+	cmd.Flags().BoolVar(&printRendered, "print-rendered", false, usagePrintRendered)
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", usageCacheDir)
+	cmd.Flags().BoolVar(&offlineRules, "offline", false, usageOffline)
+	cmd.Flags().StringArrayVar(&publicKeys, "public-key", nil, usagePublicKey)
+	cmd.Flags().StringArrayVar(&rulesDigests, "digest", nil, usageDigest)
+	cmd.Flags().BoolVar(&requireSigned, "require-signed", false, usageRequireSigned)
+}
+
+// loadRules loads the rules from the mapping file, which can be a local file, an URL or a file in a git repository,
+// with parameters given by --set. Signatures and the digest of the files are verified if requested
+func loadRules() (rules.TagRules, *remote.Source, error) {
+	if err := loadPlugins(); err != nil {
+		return rules.TagRules{}, nil, err
+	}
+	opts, err := verifyOptions()
+	if err != nil {
+		return rules.TagRules{}, nil, err
+	}
+
+	params := make(map[string]string)
+	for _, param := range ruleParams {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return rules.TagRules{}, nil, errors.Errorf("invalid parameter %q, expected key=value", param)
+		}
+		params[kv[0]] = kv[1]
+	}
+
+	fetcher := remote.NewFetcher(cacheDir)
+	fetcher.Offline = offlineRules
+	fetcher.Signatures = len(publicKeys) > 0
+	if remote.IsBlob(mappingFile) {
+		authorizer, err := session.NewStorageAuthorizerFromFile()
+		if err != nil {
+			log.Warnf("Blob %s will be fetched without authorization: %s", mappingFile, err)
+		} else {
+			fetcher.Authorizer = authorizer
+		}
+	}
+	source, err := fetcher.Fetch(mappingFile)
+	if err != nil {
+		return rules.TagRules{}, nil, errors.Wrapf(err, "Can't get rules from %s", mappingFile)
+	}
+
+	opts = append(opts, rules.WithParams(params))
+	if remote.IsHTTP(mappingFile) {
+		opts = append(opts, rules.WithOrigin(mappingFile, func(location string) (string, error) {
+			included, err := fetcher.Fetch(location)
+			if err != nil {
+				return "", err
+			}
+			return included.Path, nil
+		}))
+	}
+	t, err := rules.NewFromFile(source.Path, opts...)
+	if err != nil {
+		return rules.TagRules{}, nil, errors.Wrapf(err, "Can't parse rules from %s", source.Location)
+	}
+	t = azure.ResolveFiles(t)
+	if err := azure.ValidateRules(t); err != nil {
+		return rules.TagRules{}, nil, errors.Wrapf(err, "Invalid rules in %s", source.Location)
+	}
+	return t, source, nil
+}
+
+// checkExec refuses the rules t loaded from source if they run commands, unless --allow-exec is given. Only the
+// commands executing actions check it
+func checkExec(t rules.TagRules, source *remote.Source) error {
+	if allowExec {
+		return nil
+	}
+	return errors.Wrapf(azure.CheckExec(t), "Invalid rules in %s, pass --allow-exec to run commands", source.Location)
+}
+
+// verifyOptions returns options verifying rules files as requested by --public-key, --digest and --require-signed
+func verifyOptions() ([]rules.Option, error) {
+	var opts []rules.Option
+	for _, filename := range publicKeys {
+		key, err := rules.LoadPublicKey(filename)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't load the public key")
+		}
+		opts = append(opts, rules.WithPublicKeys(key))
+	}
+	if len(rulesDigests) > 0 {
+		opts = append(opts, rules.WithDigest(rulesDigests...))
+	}
+	if requireSigned {
+		if len(publicKeys) == 0 {
+			return nil, errors.New("--require-signed needs at least one --public-key")
+		}
+		opts = append(opts, rules.RequireSigned())
+	}
+	return opts, nil
+}
+
+// backupMetadata returns metadata of a backup made by command with rules from source
+func backupMetadata(command string, source *remote.Source) azure.BackupMetadata {
+	metadata := azure.BackupMetadata{Command: command}
+	if source != nil {
+		metadata.RulesSource = source.Location
+		metadata.RulesRevision = source.Revision
+		metadata.RulesDigest = source.Digest
+	}
+	return metadata
+}
+
+// printRules prints the rules in YAML
+func printRules(t rules.TagRules) error {
+	out, err := yaml.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "can't render rules")
+	}
+	fmt.Print(string(out))
+
+	// the scopes of the included files narrow the scope of the rules, they aren't part of the rules format
+	for _, scope := range t.IncludedScopes {
+		out, err := yaml.Marshal(scope)
+		if err != nil {
+			return errors.Wrap(err, "can't render rules")
+		}
+		fmt.Println("# scope of an included file:")
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			fmt.Println("#   " + line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+const (
+	scannerARM   = "arm"   // lists the resources of each resource group
+	scannerGraph = "graph" // queries Azure Resource Graph
+
+	usageScanner       = "How resources are scanned: arm lists the resources of each resource group, graph queries Azure Resource Graph"
+	usageIncludeRG     = "Only scan the resource groups matching this glob (can be repeated)"
+	usageExcludeRG     = "Never scan the resource groups matching this glob (can be repeated)"
+	usageIncludeType   = "Only scan the resources of types matching this glob, like Microsoft.Compute/* (can be repeated)"
+	usageExcludeType   = "Never scan the resources of types matching this glob (can be repeated)"
+	usageIncludeRegion = "Only scan the resources in regions matching this glob (can be repeated)"
+	usageExcludeRegion = "Never scan the resources in regions matching this glob (can be repeated)"
+	usageConcurrency   = "Number of resource groups scanned at the same time"
+	usageRetries       = "Number of retries of throttled and failed requests"
+	usagePartial       = "Go on when some resource groups or subscriptions can't be scanned, skipping them"
+)
+
+var (
+	scannerKind string
+	flagScope   rules.Scope
+	concurrency int
+	retries     int
+	partial     bool
+)
+
+// addScannerFlag adds the flag choosing the scanner and the scope flags to cmd
+func addScannerFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&scannerKind, "scanner", scannerARM, usageScanner)
+	cmd.Flags().IntVar(&concurrency, "concurrency", azure.DefaultConcurrency, usageConcurrency)
+	cmd.Flags().IntVar(&retries, "retries", azure.DefaultRetries, usageRetries)
+	cmd.Flags().BoolVar(&partial, "partial", false, usagePartial)
+	addScopeFlags(cmd)
+}
+
+// addScopeFlags adds the flags limiting the scanned resources to cmd
+func addScopeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&flagScope.ResourceGroups, "include-rg", nil, usageIncludeRG)
+	cmd.Flags().StringArrayVar(&flagScope.ExcludeResourceGroups, "exclude-rg", nil, usageExcludeRG)
+	cmd.Flags().StringArrayVar(&flagScope.Types, "include-type", nil, usageIncludeType)
+	cmd.Flags().StringArrayVar(&flagScope.ExcludeTypes, "exclude-type", nil, usageExcludeType)
+	cmd.Flags().StringArrayVar(&flagScope.Regions, "include-region", nil, usageIncludeRegion)
+	cmd.Flags().StringArrayVar(&flagScope.ExcludeRegions, "exclude-region", nil, usageExcludeRegion)
+}
+
+// checkScanner validates the scanner flags
+func checkScanner() error {
+	if scannerKind != scannerARM && scannerKind != scannerGraph {
+		return errors.Errorf("unknown scanner %q, use %s or %s", scannerKind, scannerARM, scannerGraph)
+	}
+	if concurrency < 1 {
+		return errors.Errorf("--concurrency must be at least 1, got %d", concurrency)
+	}
+	if retries < 0 {
+		return errors.Errorf("--retries can't be negative, got %d", retries)
+	}
+	return nil
+}
+
+// scanScope returns the scopes of the rules t, if given, and of the command line flags
+func scanScope(t *rules.TagRules) rules.Scopes {
+	var scopes rules.Scopes
+	if t != nil {
+		scopes = t.AllScopes()
+	}
+	if !flagScope.IsZero() {
+		scopes = append(scopes, flagScope)
+	}
+	return scopes
+}
+
+// newScanner returns the scanner of the subscriptions subs chosen by --scanner, limited to the scope of the rules t
+// and of the flags. The graph scanner only returns the resources which can match the rules t, if given
+func newScanner(sess *session.AzureSession, subs []session.Subscription, t *rules.TagRules) azure.Scanner {
+	scope := scanScope(t)
+	if scannerKind != scannerGraph {
+		scanner := azure.NewSubscriptionsScanner(sess, subs)
+		for _, s := range scanner.Scanners {
+			s.Scope = scope
+			s.Concurrency, s.Partial = concurrency, partial
+			s.SetRetries(retries, azure.DefaultRetryBackoff)
+		}
+		return scanner
+	}
+	scanner := azure.NewGraphScanner(sess, subs)
+	scanner.Scope = scope
+	scanner.SetRetries(retries, azure.DefaultRetryBackoff)
+	if t != nil {
+		scanner.Filter = azure.GraphFilter(*t)
+		log.Infof("Resource Graph filter: %s", scanner.Filter)
+	}
+	return scanner
+}
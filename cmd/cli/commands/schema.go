@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+const (
+	usageSchemaOutput = "Write the schema to a file instead of the standard output"
+)
+
+var (
+	schemaOutput string
+)
+
+func init() {
+	rootCmd.AddCommand(schemaCommand)
+	schemaCommand.Flags().StringVarP(&schemaOutput, "output", "o", "", usageSchemaOutput)
+}
+
+var schemaCommand = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema of the rules files",
+	Long:  "Prints a JSON Schema of the rules files, with all supported types of conditions and actions and their parameters. Editors use it for autocompletion and validation.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadPlugins(); err != nil {
+			return err
+		}
+		conditions, actions := azure.Specs()
+		schema, err := rules.JSONSchema(conditions, actions)
+		if err != nil {
+			return err
+		}
+
+		if schemaOutput == "" {
+			fmt.Println(string(schema))
+			return nil
+		}
+		if err := ioutil.WriteFile(schemaOutput, append(schema, '\n'), 0644); err != nil {
+			return errors.Wrap(err, "can't write the schema")
+		}
+		fmt.Printf("Schema written to: [%s]\n", schemaOutput)
+		return nil
+	},
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+const (
+	usageSigningKey  = "Location of the ed25519 private key (PEM) signing the rules files"
+	usageGenerateKey = "Generate an ed25519 key pair in <prefix>.key and <prefix>.pub and exit"
+)
+
+var (
+	signingKey  string
+	generateKey string
+)
+
+func init() {
+	rootCmd.AddCommand(signCommand)
+	signCommand.Flags().StringVarP(&signingKey, "key", "k", "", usageSigningKey)
+	signCommand.Flags().StringVar(&generateKey, "generate-key", "", usageGenerateKey)
+}
+
+var signCommand = &cobra.Command{
+	Use:   "sign [rules files]",
+	Short: "Sign rules files with a detached signature",
+	Long:  "Signs each of the rules files with an ed25519 key, writing the signature next to the file with a .sig extension. Signatures are verified with --public-key when the rules are loaded.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if generateKey != "" {
+			if err := rules.GenerateKeys(generateKey); err != nil {
+				return err
+			}
+			fmt.Printf("Keys written to: [%s.key] and [%s.pub]\n", generateKey, generateKey)
+			return nil
+		}
+
+		if signingKey == "" || len(args) == 0 {
+			return errors.New("a key (--key) and at least one rules file are required")
+		}
+		key, err := rules.LoadPrivateKey(signingKey)
+		if err != nil {
+			return errors.Wrap(err, "can't load the signing key")
+		}
+
+		for _, filename := range args {
+			sigFile, err := rules.SignFile(filename, key)
+			if err != nil {
+				return errors.Wrapf(err, "can't sign %s", filename)
+			}
+			dat, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return errors.Wrapf(err, "can't read %s", filename)
+			}
+			fmt.Printf("Signed [%s] in [%s], sha256 [%s]\n", filename, sigFile, rules.Digest(dat))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+const (
+	usageScanOutput   = "File the inventory of the scanned resources is written to"
+	usageFromSnapshot = "Evaluate the rules against the resources of a snapshot written by scan, without calling Azure. Implies --dry"
+	usageInput        = "Evaluate the rules against the resources of an inventory exported by another tool, given as format:file, " +
+		"where format is az-json (az resource list -o json) or arg-csv (Resource Graph CSV export). Implies --dry"
+)
+
+var (
+	scanOutput   string
+	fromSnapshot string
+	inputFile    string
+)
+
+func init() {
+	rootCmd.AddCommand(scanCommand)
+	scanCommand.Flags().StringVarP(&scanOutput, "output", "o", "", usageScanOutput)
+	scanCommand.MarkFlagRequired("output")
+	addSubscriptionFlags(scanCommand)
+	addScannerFlag(scanCommand)
+}
+
+// addSnapshotFlags adds the flags running cmd against a snapshot or an imported inventory to cmd
+func addSnapshotFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&fromSnapshot, "from-snapshot", "", usageFromSnapshot)
+	cmd.Flags().StringVar(&inputFile, "input", "", usageInput)
+}
+
+// offline returns if the command runs against a snapshot or an imported inventory instead of Azure
+func offline() bool {
+	return fromSnapshot != "" || inputFile != ""
+}
+
+// readSnapshot reads the snapshot given by --from-snapshot or imports the inventory given by --input
+func readSnapshot() (*azure.Snapshot, string, error) {
+	if fromSnapshot != "" && inputFile != "" {
+		return nil, "", errors.New("only one of --from-snapshot and --input can be given")
+	}
+	if fromSnapshot != "" {
+		snapshot, err := azure.ReadSnapshot(fromSnapshot)
+		return snapshot, fromSnapshot, err
+	}
+	parts := strings.SplitN(inputFile, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", errors.Errorf("invalid --input %q, expected format:file, where format is one of %s", inputFile, strings.Join(azure.InputFormats, ", "))
+	}
+	snapshot, err := azure.ImportSnapshot(parts[0], parts[1])
+	return snapshot, parts[1], err
+}
+
+// openScanner returns the session and the scanner of the snapshot given by --from-snapshot or --input, which is
+// empty as Azure isn't called, or of the subscriptions selected by the flags. Both are limited to the scope of the
+// rules t, if given, and of the flags. It fails if the rules use fields of resources the scan doesn't fill
+func openScanner(t *rules.TagRules) (*session.AzureSession, azure.Scanner, error) {
+	if offline() {
+		snapshot, name, err := readSnapshot()
+		if err != nil {
+			return nil, nil, err
+		}
+		scanner := azure.SnapshotScanner{Snapshot: snapshot, Scope: scanScope(t)}
+		if err := checkFields(t, scanner); err != nil {
+			return nil, nil, err
+		}
+		meta := snapshot.Metadata
+		fmt.Printf("!! Running against snapshot %s of %d resource(s) scanned at %s by the %s scanner\n",
+			name, len(snapshot.Resources), meta.CreatedAt.Format("2006-01-02 15:04:05 MST"), meta.Scanner)
+		return &session.AzureSession{}, scanner, nil
+	}
+
+	sess, err := session.NewFromFile()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Could not create session")
+	}
+	subs, err := selectSubscriptions(sess)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := newScanner(sess, subs, t)
+	if err := checkFields(t, scanner); err != nil {
+		return nil, nil, err
+	}
+	return sess, scanner, nil
+}
+
+// checkFields checks that the rules t, if given, only use the optional fields of resources filled by scanner
+func checkFields(t *rules.TagRules, scanner azure.Scanner) error {
+	if t == nil {
+		return nil
+	}
+	return errors.Wrap(azure.CheckFields(*t, scanner.Fields()), "The rules can't be evaluated on the scanned resources")
+}
+
+var scanCommand = &cobra.Command{
+	Use:   "scan",
+	Short: "Write an inventory of the resources to a file, to run rules against it offline",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkScanner(); err != nil {
+			return err
+		}
+
+		sess, err := session.NewFromFile()
+		if err != nil {
+			return errors.Wrap(err, "Could not create session")
+		}
+		subs, err := selectSubscriptions(sess)
+		if err != nil {
+			return err
+		}
+
+		metadata := azure.SnapshotMetadata{ToolVersion: Version, Scanner: scannerKind}
+		for _, sub := range subs {
+			metadata.Subscriptions = append(metadata.Subscriptions, sub.ID)
+		}
+		snapshot, err := azure.NewSnapshot(newScanner(sess, subs, nil), metadata)
+		if err != nil {
+			return err
+		}
+		if err := azure.WriteSnapshot(scanOutput, snapshot); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d resource(s) in %d resource group(s) to %s\n", len(snapshot.Resources), len(snapshot.ResourceGroups), scanOutput)
+		return nil
+	},
+}
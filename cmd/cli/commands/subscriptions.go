@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+const (
+	usageSubscription     = "Subscription (ID or name) to scan, can be repeated (default the subscription of the auth file)"
+	usageAllSubscriptions = "Scan all the enabled subscriptions"
+	usageSubscriptionName = "Scan the subscriptions whose name matches the pattern, like prod-*, can be repeated"
+	usageSubscriptionTag  = "Scan the subscriptions with the tag key=value, can be repeated"
+	usageManagementGroup  = "Scan the subscriptions under the management group, at any depth"
+)
+
+var (
+	subscriptionIDs   []string
+	allSubscriptions  bool
+	subscriptionNames []string
+	subscriptionTags  []string
+	managementGroup   string
+
+	subscriptionLabels = make(map[string]string) // names of the selected subscriptions by ID
+)
+
+// addSubscriptionFlags adds the flags selecting the subscriptions to scan to cmd
+func addSubscriptionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&subscriptionIDs, "subscription", nil, usageSubscription)
+	cmd.Flags().BoolVar(&allSubscriptions, "all-subscriptions", false, usageAllSubscriptions)
+	cmd.Flags().StringArrayVar(&subscriptionNames, "subscription-name", nil, usageSubscriptionName)
+	cmd.Flags().StringArrayVar(&subscriptionTags, "subscription-tag", nil, usageSubscriptionTag)
+	cmd.Flags().StringVar(&managementGroup, "management-group", "", usageManagementGroup)
+}
+
+// subscriptionFilter returns the filter given by the subscription flags
+func subscriptionFilter() (session.SubscriptionFilter, error) {
+	filter := session.SubscriptionFilter{
+		All:             allSubscriptions,
+		IDs:             subscriptionIDs,
+		Names:           subscriptionNames,
+		ManagementGroup: managementGroup,
+	}
+	for _, tag := range subscriptionTags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return filter, errors.Errorf("invalid subscription tag %q, expected key=value", tag)
+		}
+		if filter.Tags == nil {
+			filter.Tags = make(map[string]string)
+		}
+		filter.Tags[kv[0]] = kv[1]
+	}
+	return filter, nil
+}
+
+// selectSubscriptions returns the subscriptions selected by the subscription flags, or the subscription of sess
+func selectSubscriptions(sess *session.AzureSession) ([]session.Subscription, error) {
+	filter, err := subscriptionFilter()
+	if err != nil {
+		return nil, err
+	}
+	if filter.IsZero() {
+		return []session.Subscription{{ID: sess.SubscriptionID}}, nil
+	}
+
+	selected, err := sess.SelectSubscriptions(filter)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Scanning %d subscription(s)\n", len(selected))
+	for _, sub := range selected {
+		subscriptionLabels[sub.ID] = sub.String()
+		if len(sub.ManagementGroups) > 0 {
+			fmt.Printf("Subscription: [%s] under [%s]\n", sub, strings.Join(sub.ManagementGroups, " / "))
+		} else {
+			fmt.Printf("Subscription: [%s]\n", sub)
+		}
+	}
+	return selected, nil
+}
+
+// subscriptionLabel returns the name and ID of the subscription id, as selected by selectSubscriptions
+func subscriptionLabel(id string) string {
+	if label, ok := subscriptionLabels[id]; ok {
+		return label
+	}
+	return id
+}
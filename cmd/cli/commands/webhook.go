@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules/remote"
+	"github.com/nordcloud/azure-tag-manager/internal/webhook"
+)
+
+const (
+	usageNotifyURL    = "Webhook the summary of the run is posted to"
+	usageNotifyFormat = "Format of the summary: json, slack or teams"
+	usageNotifySecret = "Secret signing the summary with HMAC-SHA256 (default $TAGMANAGER_NOTIFY_SECRET)"
+)
+
+var (
+	notifyURL    string
+	notifyFormat string
+	notifySecret string
+)
+
+// RunEvent is posted to --notify-url at the end of a run
+type RunEvent struct {
+	Event   string        `json:"event"` // always run
+	Command string        `json:"command"`
+	DryRun  bool          `json:"dryRun"`
+	Rules   string        `json:"rules,omitempty"` // location, revision and digest of the rules
+	Summary azure.Summary `json:"summary"`
+}
+
+// addNotifyFlags adds the flags of the run summary webhook to cmd
+func addNotifyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&notifyURL, "notify-url", "", usageNotifyURL)
+	cmd.Flags().StringVar(&notifyFormat, "notify-format", webhook.FormatJSON, usageNotifyFormat)
+	cmd.Flags().StringVar(&notifySecret, "notify-secret", "", usageNotifySecret)
+}
+
+// checkNotify validates the flags of the run summary webhook
+func checkNotify() error {
+	if notifyURL == "" {
+		return nil
+	}
+	return webhook.CheckFormat(notifyFormat)
+}
+
+// notifyRun posts the summary of the run to --notify-url, if it is set
+func notifyRun(command string, dryRun bool, source *remote.Source, summary azure.Summary) error {
+	if notifyURL == "" {
+		return nil
+	}
+
+	event := RunEvent{Event: "run", Command: command, DryRun: dryRun, Summary: summary}
+	if source != nil {
+		event.Rules = source.String()
+	}
+
+	title := fmt.Sprintf("tagmanager %s: %d rule execution(s) on %d resource(s)", command, event.Summary.Executions, event.Summary.Resources)
+	if dryRun {
+		title += " (dry run)"
+	}
+	var lines []string
+	if event.Summary.NonCompliant > 0 {
+		lines = append(lines, fmt.Sprintf("%d non-compliant", event.Summary.NonCompliant))
+	}
+	names := make([]string, 0, len(event.Summary.Rules))
+	for name := range event.Summary.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("- %s: %d", name, event.Summary.Rules[name]))
+	}
+	if event.Rules != "" {
+		lines = append(lines, "Rules: "+event.Rules)
+	}
+
+	secret := notifySecret
+	if secret == "" {
+		secret = os.Getenv("TAGMANAGER_NOTIFY_SECRET")
+	}
+	sender := webhook.NewSender(secret)
+	if err := sender.Send(context.Background(), notifyURL, notifyFormat, webhook.Message{Title: title, Text: strings.Join(lines, "\n"), Data: event}); err != nil {
+		return errors.Wrap(err, "can't post the summary of the run")
+	}
+	fmt.Println("Summary of the run posted to the webhook")
+	return nil
+}
@@ -2,6 +2,10 @@ package main
 
 import "github.com/nordcloud/azure-tag-manager/cmd/cli/commands"
 
+// version is set by the release build with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
+	commands.Version = version
 	commands.Execute()
 }
@@ -1,9 +1,13 @@
 package azure
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -15,8 +19,24 @@ import (
 
 // BackupEntry represents one resource tags backup
 type BackupEntry struct {
-	ID   string             `json:"id"`
-	Tags map[string]*string `json:"tags"`
+	ID           string             `json:"id"`
+	Subscription string             `json:"subscription,omitempty"`
+	Tags         map[string]*string `json:"tags"`
+}
+
+// Backup represents a backup file, the tags of resources and the metadata of the run that made it
+type Backup struct {
+	Metadata BackupMetadata `json:"metadata"`
+	Entries  []BackupEntry  `json:"entries"`
+}
+
+// BackupMetadata describes the run that made a backup
+type BackupMetadata struct {
+	CreatedAt     time.Time `json:"createdAt"`
+	Command       string    `json:"command,omitempty"`
+	RulesSource   string    `json:"rulesSource,omitempty"`   // location the rules were loaded from
+	RulesRevision string    `json:"rulesRevision,omitempty"` // git commit, blob version or ETag of the rules
+	RulesDigest   string    `json:"rulesDigest,omitempty"`   // sha256 of the rules file
 }
 
 // Restorer provides interface for restorers
@@ -29,18 +49,24 @@ type TagRestorer struct {
 	Session         *session.AzureSession  // session to connect to Azure
 	ResourcesClient resourcesapi.ClientAPI // client to the resources API
 	Backup          []BackupEntry          // list of backup entries
+	Metadata        BackupMetadata         // metadata of the run that made the backup, empty for old backups
+	Incomplete      bool                   // the backup misses the end of its entries, as its run was interrupted
 }
 
 //NewBackupFromMatched makes a file backup from the resources in matched to a json file in directory
-func NewBackupFromMatched(matched map[string]Matched, directory string) string {
-	var backup []BackupEntry
+func NewBackupFromMatched(matched map[string]Matched, directory string, metadata BackupMetadata) string {
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+	backup := Backup{Metadata: metadata}
 
 	for ID, matched := range matched {
 		entry := &BackupEntry{
-			ID:   ID,
-			Tags: matched.Resource.Tags,
+			ID:           ID,
+			Subscription: matched.Resource.SubscriptionID(),
+			Tags:         matched.Resource.Tags,
 		}
-		backup = append(backup, *entry)
+		backup.Entries = append(backup.Entries, *entry)
 	}
 	tmpfile, err := ioutil.TempFile(directory, "tagmanager.*.json")
 	if err != nil {
@@ -58,6 +84,67 @@ func NewBackupFromMatched(matched map[string]Matched, directory string) string {
 	return tmpfile.Name()
 }
 
+// BackupWriter writes a backup file entry by entry, so that the tags of streamed resources are saved before their
+// actions are executed
+type BackupWriter struct {
+	file    *os.File
+	entries int
+}
+
+// NewBackupWriter creates a backup file in directory, like NewBackupFromMatched, and writes the metadata
+func NewBackupWriter(directory string, metadata BackupMetadata) (*BackupWriter, error) {
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+	jsonMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't encode backup metadata")
+	}
+	file, err := ioutil.TempFile(directory, "tagmanager.*.json")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create backup file")
+	}
+	if _, err := fmt.Fprintf(file, `{"metadata":%s,"entries":[`, jsonMetadata); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "can't write backup file")
+	}
+	return &BackupWriter{file: file}, nil
+}
+
+// Name returns the name of the backup file
+func (w *BackupWriter) Name() string {
+	return w.file.Name()
+}
+
+// Add writes the tags of the matched resource to the file, and flushes it to disk
+func (w *BackupWriter) Add(matched Matched) error {
+	jsonEntry, err := json.Marshal(BackupEntry{
+		ID:           matched.Resource.ID,
+		Subscription: matched.Resource.SubscriptionID(),
+		Tags:         matched.Resource.Tags,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can't encode backup of %s", matched.Resource.ID)
+	}
+	if w.entries > 0 {
+		jsonEntry = append([]byte(","), jsonEntry...)
+	}
+	if _, err := w.file.Write(jsonEntry); err != nil {
+		return errors.Wrapf(err, "can't write backup of %s", matched.Resource.ID)
+	}
+	w.entries++
+	return errors.Wrap(w.file.Sync(), "can't flush backup file")
+}
+
+// Close ends the list of entries and closes the file
+func (w *BackupWriter) Close() error {
+	if _, err := w.file.WriteString("]}"); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "can't write backup file")
+	}
+	return errors.Wrap(w.file.Close(), "can't close backup file")
+}
+
 // Restore restores tags from a backup file provided in TagRestorer
 func (t TagRestorer) Restore() error {
 	for _, backupEntry := range t.Backup {
@@ -84,20 +171,36 @@ func NewRestorerFromFile(filename string, s *session.AzureSession) *TagRestorer
 	resClient := resources.NewClient(s.SubscriptionID)
 	resClient.Authorizer = s.Authorizer
 
-	var backup []BackupEntry
+	var (
+		backup     Backup
+		incomplete bool
+	)
 	dat, err := ioutil.ReadFile(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
 	byt := []byte(dat)
-	if err := json.Unmarshal(byt, &backup); err != nil {
+	// backups made before metadata was added are a list of entries
+	if bytes.HasPrefix(bytes.TrimSpace(byt), []byte("[")) {
+		err = json.Unmarshal(byt, &backup.Entries)
+	} else {
+		err = json.Unmarshal(byt, &backup)
+		// backups of streaming runs which were interrupted miss the end of the list of entries
+		if err != nil && json.Unmarshal(append(byt, "]}"...), &backup) == nil {
+			log.Warnf("Backup %s is incomplete, its run was interrupted after %d entries", filename, len(backup.Entries))
+			incomplete, err = true, nil
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
 	restorer := &TagRestorer{
 		Session:         s,
 		ResourcesClient: &resClient,
-		Backup:          backup,
+		Backup:          backup.Entries,
+		Metadata:        backup.Metadata,
+		Incomplete:      incomplete,
 	}
 	return restorer
 }
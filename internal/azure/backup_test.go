@@ -0,0 +1,38 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+func TestBackupWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	w, err := NewBackupWriter(dir, BackupMetadata{Command: "rewrite"})
+	assert.Nil(t, err)
+	for _, res := range testResources[:2] {
+		assert.Nil(t, w.Add(Matched{Resource: res}))
+	}
+
+	// an interrupted run leaves the list of entries open
+	restorer := NewRestorerFromFile(w.Name(), &session.AzureSession{})
+	assert.True(t, restorer.Incomplete)
+	assert.Len(t, restorer.Backup, 2)
+
+	assert.Nil(t, w.Close())
+	restorer = NewRestorerFromFile(w.Name(), &session.AzureSession{})
+	assert.False(t, restorer.Incomplete)
+	assert.Equal(t, "rewrite", restorer.Metadata.Command)
+	assert.False(t, restorer.Metadata.CreatedAt.IsZero())
+	assert.Equal(t, []BackupEntry{
+		{ID: "1", Tags: map[string]*string{"test": String("test")}},
+		{ID: "2", Tags: map[string]*string{"test2": String("test2"), "test3": String("test3")}},
+	}, restorer.Backup)
+}
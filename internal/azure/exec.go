@@ -0,0 +1,171 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+const (
+	execTimeout   = 30 * time.Second // timeout of commands without a timeout parameter
+	execMaxOutput = 4096             // bytes of output kept for reports
+)
+
+// execSpec describes actions running a command for each matched resource
+var execSpec = rules.Spec{
+	Type:        "exec",
+	Description: "Runs a command with the resource as JSON on stdin and in TAGMANAGER_* environment variables",
+	Params: []rules.Param{
+		{Name: "command", Type: rules.StringListParam, Required: true, Description: "The command and its arguments"},
+		{Name: "timeout", Type: rules.StringParam, Description: "Timeout of the command, like 30s"},
+		{Name: "parseOutput", Type: rules.StringParam, Description: "If true, the output of the command is a JSON object with tags to set and delete"},
+		{Name: "dryRun", Type: rules.StringParam, Description: "If true, the command runs in dry runs as well, with TAGMANAGER_DRY_RUN=true"},
+	},
+	Validate: func(item map[string]interface{}) error {
+		p := rules.ActionItem(item)
+		if len(p.GetStrings("command")) == 0 || p.GetStrings("command")[0] == "" {
+			return errors.New("command can't be empty")
+		}
+		if _, err := execTimeoutOf(p); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// CheckExec returns an error listing the rules of ruleDef with exec actions. Rules files can come from URLs and
+// shared repositories, so running their commands must be allowed explicitly
+func CheckExec(ruleDef rules.TagRules) error {
+	var errs rules.Errors
+	for i, rule := range ruleDef.Rules {
+		for j, action := range rule.Actions {
+			if action.GetType() == execSpec.Type {
+				errs = append(errs, errors.Errorf("rule %d (%q): action %d runs a command, which is not allowed", i, rule.Name, j))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// execInput is written to the standard input of commands
+type execInput struct {
+	Resource ResourceDocument `json:"resource"`
+	DryRun   bool             `json:"dryRun"`
+}
+
+// execOutput is the output of commands with parseOutput
+type execOutput struct {
+	Set    map[string]string `json:"set"`
+	Delete []string          `json:"delete"`
+}
+
+func execTimeoutOf(p rules.ActionItem) (time.Duration, error) {
+	if p.GetString("timeout") == "" {
+		return execTimeout, nil
+	}
+	timeout, err := time.ParseDuration(p.GetString("timeout"))
+	if err != nil || timeout <= 0 {
+		return 0, errors.Errorf("invalid timeout %q", p.GetString("timeout"))
+	}
+	return timeout, nil
+}
+
+// runCommand runs the command of the action p for data and returns its output. With parseOutput, the
+// output is parsed as tag changes, which are applied unless the tagger runs in dry run mode
+func (t *Tagger) runCommand(p rules.ActionItem, data *Resource) (string, error) {
+	command := p.GetStrings("command")
+	if len(command) == 0 {
+		return "", errors.New("command can't be empty")
+	}
+	timeout, err := execTimeoutOf(p)
+	if err != nil {
+		return "", err
+	}
+
+	doc := NewResourceDocument(data)
+	input, err := json.Marshal(execInput{Resource: doc, DryRun: t.dryRun})
+	if err != nil {
+		return "", errors.Wrap(err, "can't encode the resource")
+	}
+	tags, _ := json.Marshal(doc.Tags)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"TAGMANAGER_RESOURCE_ID="+doc.ID,
+		"TAGMANAGER_RESOURCE_SUBSCRIPTION="+doc.Subscription,
+		"TAGMANAGER_RESOURCE_NAME="+doc.Name,
+		"TAGMANAGER_RESOURCE_REGION="+doc.Region,
+		"TAGMANAGER_RESOURCE_GROUP="+doc.ResourceGroup,
+		"TAGMANAGER_RESOURCE_TYPE="+doc.Type,
+		"TAGMANAGER_RESOURCE_KIND="+doc.Kind,
+		"TAGMANAGER_RESOURCE_TAGS="+string(tags),
+		fmt.Sprintf("TAGMANAGER_DRY_RUN=%t", t.dryRun),
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return truncate(stdout.String() + stderr.String()), errors.Errorf("command %s timed out after %s", command[0], timeout)
+	}
+	if err != nil {
+		return truncate(stdout.String() + stderr.String()), errors.Wrapf(err, "command %s failed: %s", command[0], truncate(stderr.String()))
+	}
+
+	if p.GetString("parseOutput") != "true" {
+		return truncate(stdout.String() + stderr.String()), nil
+	}
+	var out execOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return truncate(stdout.String()), errors.Wrapf(err, "invalid output of command %s", command[0])
+	}
+	changes := TagChanges{Set: out.Set, Delete: out.Delete}
+	if !t.dryRun {
+		if err := t.applyTagChanges(data.ID, changes); err != nil {
+			return "", err
+		}
+	}
+	return truncate(changes.String() + stderr.String()), nil
+}
+
+// String returns the changes in the form set [k = v, ...] delete [k, ...]
+func (c TagChanges) String() string {
+	var set []string
+	for _, k := range sortedTags(c.Set) {
+		set = append(set, k+" = "+c.Set[k])
+	}
+	return fmt.Sprintf("set [%s] delete [%s]\n", strings.Join(set, ", "), strings.Join(c.Delete, ", "))
+}
+
+func sortedTags(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func truncate(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > execMaxOutput {
+		return output[:execMaxOutput] + "..."
+	}
+	return output
+}
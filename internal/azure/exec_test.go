@@ -0,0 +1,106 @@
+package azure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func execRules(actions ...rules.ActionItem) rules.TagRules {
+	return rules.TagRules{Rules: []rules.Rule{
+		{Name: "exec", Conditions: []rules.ConditionItem{{"type": "resEqual", "resource": "name"}}, Actions: actions},
+	}}
+}
+
+func TestTagger_ExecAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  rules.ActionItem
+		dryRun  bool
+		want    []ActionOutput
+		wantErr bool
+	}{
+		{
+			name:   "environment and stdin",
+			action: rules.ActionItem{"type": "exec", "command": []interface{}{"sh", "-c", `echo "$TAGMANAGER_RESOURCE_ID $TAGMANAGER_RESOURCE_GROUP $TAGMANAGER_RESOURCE_TAGS"; cat`}},
+			want:   []ActionOutput{{Type: "exec", Output: `1 test {"test":"test"}` + "\n" + `{"resource":{"id":"1","subscription":"","name":"name","region":"westeurope","resourceGroup":"test","type":"","kind":"","tags":{"test":"test"}},"dryRun":false}`}},
+		},
+		{
+			name:   "skipped in dry run",
+			action: rules.ActionItem{"type": "exec", "command": []interface{}{"false"}},
+			dryRun: true,
+		},
+		{
+			name:   "runs in dry run if asked",
+			action: rules.ActionItem{"type": "exec", "command": []interface{}{"sh", "-c", `echo "dry run $TAGMANAGER_DRY_RUN"`}, "dryRun": "true"},
+			dryRun: true,
+			want:   []ActionOutput{{Type: "exec", Output: "dry run true"}},
+		},
+		{
+			name:   "parsed output is not applied in dry run",
+			action: rules.ActionItem{"type": "exec", "command": []interface{}{"echo", `{"set": {"b": "2", "a": "1"}, "delete": ["test"]}`}, "dryRun": "true", "parseOutput": "true"},
+			dryRun: true,
+			want:   []ActionOutput{{Type: "exec", Output: "set [a = 1, b = 2] delete [test]"}},
+		},
+		{
+			name:    "failure",
+			action:  rules.ActionItem{"type": "exec", "command": []interface{}{"sh", "-c", "echo broken >&2; exit 3"}},
+			wantErr: true,
+		},
+		{
+			name:    "timeout",
+			action:  rules.ActionItem{"type": "exec", "command": []interface{}{"sleep", "5"}, "timeout": "100ms"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tagger := Tagger{
+				ResourcesClient: new(mocks.ClientAPI),
+				Rules:           execRules(tt.action),
+				Matched:         make(map[string]Matched),
+				dryRun:          tt.dryRun,
+			}
+			tagger.InitActionMap()
+			tagger.InitCondMap()
+			tagger.EvaluateRules(testResources)
+			ael, err := tagger.ExecuteActions()
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Len(t, ael, 1)
+			assert.Equal(t, tt.want, ael[0].Outputs)
+		})
+	}
+}
+
+func TestTagger_ExecActionParseOutput(t *testing.T) {
+	mockClient := new(mocks.ClientAPI)
+	mockClient.On("GetByID", context.Background(), "1").Return(resources.GenericResource{ID: String("1"), Tags: map[string]*string{"test": String("test")}}, nil)
+	mockClient.On("UpdateByID", context.Background(), "1", resources.GenericResource{Tags: map[string]*string{"ticket": String("OPS-1")}}).Return(resources.UpdateByIDFuture{}, nil)
+
+	tagger := Tagger{
+		ResourcesClient: mockClient,
+		Rules:           execRules(rules.ActionItem{"type": "exec", "command": []interface{}{"echo", `{"set": {"ticket": "OPS-1"}, "delete": ["test"]}`}, "parseOutput": "true"}),
+		Matched:         make(map[string]Matched),
+	}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	tagger.EvaluateRules(testResources)
+	_, err := tagger.ExecuteActions()
+	assert.Nil(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestCheckExec(t *testing.T) {
+	assert.Nil(t, CheckExec(execRules(rules.ActionItem{"type": "addTag", "tag": "a", "value": "b"})))
+	assert.EqualError(t, CheckExec(execRules(rules.ActionItem{"type": "exec", "command": []interface{}{"true"}})),
+		`rule 0 ("exec"): action 0 runs a command, which is not allowed`)
+}
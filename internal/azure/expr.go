@@ -0,0 +1,120 @@
+package azure
+
+import (
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+var (
+	exprEnvOnce sync.Once
+	exprEnv     *cel.Env
+	exprEnvErr  error
+	exprCache   sync.Map // compiled programs by expression
+)
+
+// exprSpec describes conditions with a CEL expression over the fields of the resource
+var exprSpec = rules.Spec{
+	Type:        "expr",
+	Description: "The CEL expression is true for the resource, with the variables id, name, region, resourceGroup, resourceType, kind, sku, skuTier, managedBy, identityType, provisioningState and tags",
+	Params:      []rules.Param{{Name: "expression", Type: rules.StringParam, Required: true, Description: "CEL expression returning a bool"}},
+	Validate: func(item map[string]interface{}) error {
+		_, err := compileExpr(rules.ConditionItem(item).GetString("expression"))
+		return err
+	},
+}
+
+// newExprEnv returns the CEL environment of expressions, declaring the fields of the resource
+func newExprEnv() (*cel.Env, error) {
+	exprEnvOnce.Do(func() {
+		exprEnv, exprEnvErr = cel.NewEnv(cel.Declarations(
+			decls.NewVar("id", decls.String),
+			decls.NewVar("name", decls.String),
+			decls.NewVar("region", decls.String),
+			decls.NewVar("resourceGroup", decls.String),
+			decls.NewVar("resourceType", decls.String),
+			decls.NewVar("kind", decls.String),
+			decls.NewVar("sku", decls.String),
+			decls.NewVar("skuTier", decls.String),
+			decls.NewVar("managedBy", decls.String),
+			decls.NewVar("identityType", decls.String),
+			decls.NewVar("provisioningState", decls.String),
+			decls.NewVar("tags", decls.NewMapType(decls.String, decls.String)),
+		))
+	})
+	return exprEnv, exprEnvErr
+}
+
+// compileExpr parses and type checks expression, programs are cached by expression
+func compileExpr(expression string) (cel.Program, error) {
+	if prg, ok := exprCache.Load(expression); ok {
+		return prg.(cel.Program), nil
+	}
+
+	env, err := newExprEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create expression environment")
+	}
+	ast, iss := env.Compile(expression)
+	if iss != nil && iss.Err() != nil {
+		return nil, errors.Errorf("invalid expression %q: %s", expression, iss.Err())
+	}
+	if resultType := cel.FormatType(ast.ResultType()); resultType != "bool" {
+		return nil, errors.Errorf("expression %q returns %s, expected bool", expression, resultType)
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't create program of expression %q", expression)
+	}
+
+	exprCache.Store(expression, prg)
+	return prg, nil
+}
+
+// evalExpr evaluates expression on data. Expressions that fail, for example on a missing tag, are false
+func evalExpr(expression string, data *Resource) bool {
+	prg, err := compileExpr(expression)
+	if err != nil {
+		log.Warnf("Expression condition ignored: %s", err)
+		return false
+	}
+
+	tags := make(map[string]string, len(data.Tags))
+	for k, v := range data.Tags {
+		if v != nil {
+			tags[k] = *v
+		}
+	}
+	out, _, err := prg.Eval(map[string]interface{}{
+		"id":                data.ID,
+		"name":              stringValue(data.Name),
+		"region":            data.Region,
+		"resourceGroup":     stringValue(data.ResourceGroup),
+		"resourceType":      stringValue(data.Type),
+		"kind":              stringValue(data.Kind),
+		"sku":               data.SKU,
+		"skuTier":           data.SKUTier,
+		"managedBy":         data.ManagedBy,
+		"identityType":      data.IdentityType,
+		"provisioningState": data.ProvisioningState,
+		"tags":              tags,
+	})
+	if err != nil {
+		log.Debugf("Expression %q failed on %s: %s", expression, data.ID, err)
+		return false
+	}
+	result, ok := out.Value().(bool)
+	return ok && result
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
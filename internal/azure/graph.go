@@ -0,0 +1,406 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/resourcegraph/mgmt/2019-04-01/resourcegraph"
+	"github.com/Azure/azure-sdk-for-go/services/resourcegraph/mgmt/2019-04-01/resourcegraph/resourcegraphapi"
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+// graphPageSize is the number of rows of each page of Resource Graph results
+const graphPageSize = 1000
+
+const (
+	graphResourceColumns = "| project id, name, type, kind, location, resourceGroup, subscriptionId, tags, sku, managedBy, " +
+		"identityType = tostring(identity.type), plan = tostring(plan.name), provisioningState = tostring(properties.provisioningState)"
+	graphGroupsQuery = "ResourceContainers | where type =~ 'microsoft.resources/subscriptions/resourcegroups'"
+)
+
+// GraphScanner scans resources with Azure Resource Graph queries, which is much faster than listing the resources
+// of each resource group
+type GraphScanner struct {
+	Session          *session.AzureSession
+	Client           resourcegraphapi.BaseClientAPI
+	Subscriptions    []string            // IDs of the subscriptions to query
+	ManagementGroups map[string][]string // management groups above the subscriptions, by subscription ID
+	Filter           string              // KQL predicate resources must match, see GraphFilter
+	Scope            rules.Scopes        // resource groups and resources out of the scope are never returned
+}
+
+// NewGraphScanner creates GraphScanner for the subscriptions subs with Azure Session s
+func NewGraphScanner(s *session.AzureSession, subs []session.Subscription) *GraphScanner {
+	client := resourcegraph.New()
+	client.Authorizer = s.Authorizer
+
+	scanner := &GraphScanner{
+		Session:          s,
+		Client:           &client,
+		ManagementGroups: make(map[string][]string),
+	}
+	for _, sub := range subs {
+		scanner.Subscriptions = append(scanner.Subscriptions, sub.ID)
+		scanner.ManagementGroups[strings.ToLower(sub.ID)] = sub.ManagementGroups
+	}
+	return scanner
+}
+
+// SetRetries sets the number of retries of throttled and failed queries and the delay before the first retry,
+// doubled before each next one
+func (g *GraphScanner) SetRetries(retries int, backoff time.Duration) {
+	if client, ok := g.Client.(*resourcegraph.BaseClient); ok {
+		client.RetryAttempts, client.RetryDuration = retries, backoff
+	}
+}
+
+// query runs the query, calling row for each row of the results until it returns an error
+func (g GraphScanner) query(ctx context.Context, query string, row func(map[string]interface{}) error) error {
+	subs, top := g.Subscriptions, int32(graphPageSize)
+	request := resourcegraph.QueryRequest{
+		Subscriptions: &subs,
+		Query:         &query,
+		Options: &resourcegraph.QueryRequestOptions{
+			Top:          &top,
+			ResultFormat: resourcegraph.ResultFormatObjectArray,
+		},
+	}
+	for {
+		resp, err := g.Client.Resources(ctx, request)
+		if err != nil {
+			return errors.Wrapf(err, "query(%q): Resources() failed", query)
+		}
+		rows, ok := resp.Data.([]interface{})
+		if !ok && resp.Data != nil {
+			return errors.Errorf("query(%q): unexpected data %T", query, resp.Data)
+		}
+		for _, r := range rows {
+			values, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := row(values); err != nil {
+				return err
+			}
+		}
+		if resp.SkipToken == nil || *resp.SkipToken == "" {
+			return nil
+		}
+		request.Options.SkipToken = resp.SkipToken
+	}
+}
+
+// groupNames returns the names of the resource groups by their lowercase subscription/name, as the resourceGroup
+// column of Resource Graph is lowercase
+func (g GraphScanner) groupNames() (map[string]string, error) {
+	names := make(map[string]string)
+	err := g.query(context.Background(), graphGroupsQuery+" | project subscriptionId, name", func(row map[string]interface{}) error {
+		name := graphString(row, "name")
+		names[strings.ToLower(graphString(row, "subscriptionId")+"/"+name)] = name
+		return nil
+	})
+	return names, err
+}
+
+// resources runs a query of resources and converts its rows
+func (g GraphScanner) resources(query string) ([]Resource, error) {
+	tab := make([]Resource, 0)
+	err := g.streamResources(context.Background(), query, func(res Resource) error {
+		tab = append(tab, res)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tab, nil
+}
+
+// streamResources runs a query of resources and calls emit with each of its rows, converted
+func (g GraphScanner) streamResources(ctx context.Context, query string, emit func(Resource) error) error {
+	groups, err := g.groupNames()
+	if err != nil {
+		return err
+	}
+
+	return g.query(ctx, query+" "+graphResourceColumns, func(row map[string]interface{}) error {
+		sub := graphString(row, "subscriptionId")
+		rg := graphString(row, "resourceGroup")
+		if name, ok := groups[strings.ToLower(sub+"/"+rg)]; ok {
+			rg = name
+		}
+		if !g.Scope.IncludesResourceGroup(rg) || !g.Scope.IncludesResource(graphString(row, "type"), graphString(row, "location")) {
+			return nil
+		}
+		sku, _ := row["sku"].(map[string]interface{})
+		return emit(Resource{
+			Platform:          "azure",
+			ID:                graphString(row, "id"),
+			Name:              String(graphString(row, "name")),
+			Region:            graphString(row, "location"),
+			Kind:              String(graphString(row, "kind")),
+			Type:              String(graphString(row, "type")),
+			Tags:              graphTags(row["tags"]),
+			ResourceGroup:     String(rg),
+			Subscription:      sub,
+			ManagementGroups:  g.ManagementGroups[strings.ToLower(sub)],
+			SKU:               graphString(sku, "name"),
+			SKUTier:           graphString(sku, "tier"),
+			ManagedBy:         graphString(row, "managedBy"),
+			IdentityType:      graphString(row, "identityType"),
+			Plan:              graphString(row, "plan"),
+			ProvisioningState: graphString(row, "provisioningState"),
+		})
+	})
+}
+
+// Fields returns the optional fields of resources filled by the scanner. The resources table of Resource Graph has
+// no creation and change times
+func (g GraphScanner) Fields() []string {
+	fields := []string{FieldManagedBy, FieldIdentity}
+	for _, sub := range g.Subscriptions {
+		if len(g.ManagementGroups[strings.ToLower(sub)]) == 0 {
+			return fields
+		}
+	}
+	if len(g.Subscriptions) > 0 {
+		fields = append(fields, FieldManagementGroups)
+	}
+	return fields
+}
+
+// GetResources returns the resources of the subscriptions matching the filter of the scanner
+func (g GraphScanner) GetResources() ([]Resource, error) {
+	return collect(context.Background(), g)
+}
+
+// StreamResources sends the resources of the subscriptions matching the filter of the scanner to out, page by page
+func (g GraphScanner) StreamResources(ctx context.Context, out chan<- Resource) error {
+	query := "Resources"
+	if g.Filter != "" {
+		query += " | where " + g.Filter
+	}
+	if scope := graphScope(g.Scope); scope != "" {
+		query += " | where " + scope
+	}
+	err := g.streamResources(ctx, query, func(res Resource) error {
+		return send(ctx, out, res)
+	})
+	if err != nil {
+		return errors.Wrap(err, "GetResources() failed")
+	}
+	return nil
+}
+
+// GetResourcesByResourceGroup returns the resources in the resource groups named rg of the subscriptions
+func (g GraphScanner) GetResourcesByResourceGroup(rg string) ([]Resource, error) {
+	if !g.Scope.IncludesResourceGroup(rg) {
+		return nil, errors.Errorf("resource group %s is out of scope", rg)
+	}
+	groups, err := g.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	if !containsFold(groups, rg) {
+		return nil, errors.Errorf("resource group %s not found", rg)
+	}
+	res, err := g.resources("Resources | where resourceGroup =~ " + kqlString(rg))
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetResourcesByResourceGroup(rg=%q) failed", rg)
+	}
+	return res, nil
+}
+
+// GetGroups returns the names of the resource groups of the subscriptions
+func (g GraphScanner) GetGroups() ([]string, error) {
+	tab := make([]string, 0)
+	err := g.query(context.Background(), graphGroupsQuery+" | project name", func(row map[string]interface{}) error {
+		if name := graphString(row, "name"); g.Scope.IncludesResourceGroup(name) {
+			tab = append(tab, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "GetGroups() failed")
+	}
+	return tab, nil
+}
+
+// ListGroups returns the resource groups of the subscriptions with their tags, with a single query
+func (g GraphScanner) ListGroups() ([]ResourceGroup, error) {
+	tab := make([]ResourceGroup, 0)
+	err := g.query(context.Background(), graphGroupsQuery+" | project subscriptionId, name, tags", func(row map[string]interface{}) error {
+		if name := graphString(row, "name"); g.Scope.IncludesResourceGroup(name) {
+			tab = append(tab, ResourceGroup{Subscription: graphString(row, "subscriptionId"), Name: name, Tags: graphTags(row["tags"])})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "ListGroups() failed")
+	}
+	return tab, nil
+}
+
+// Version returns the version of the SDK and of the API the scanner queries resources with
+func (g GraphScanner) Version() string {
+	return resourcegraph.UserAgent()
+}
+
+// GetResourceGroupTags returns a map of key value tags of the resource group rg, the first one found in the subscriptions
+func (g GraphScanner) GetResourceGroupTags(rg string) (map[string]*string, error) {
+	var tags map[string]*string
+	found := false
+	err := g.query(context.Background(), graphGroupsQuery+" | where name =~ "+kqlString(rg)+" | project tags", func(row map[string]interface{}) error {
+		if !found {
+			tags, found = graphTags(row["tags"]), true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetResourceGroupTags(rg=%s) failed", rg)
+	}
+	if !found {
+		return nil, errors.Errorf("resource group %s not found", rg)
+	}
+	return tags, nil
+}
+
+// GraphFilter returns a KQL predicate of the resources which can match the rules, or an empty string if all the
+// resources can. Only some conditions are translated, so the resources matching the predicate are still evaluated
+func GraphFilter(t rules.TagRules) string {
+	var ruleFilters []string
+	for _, rule := range t.Rules {
+		if rule.GetMode() == rules.ModeDisabled {
+			continue
+		}
+		var conds []string
+		for _, cond := range rule.Conditions {
+			if filter, ok := graphCondition(cond); ok {
+				conds = append(conds, filter)
+			}
+		}
+		if len(conds) == 0 {
+			return ""
+		}
+		ruleFilters = append(ruleFilters, "("+strings.Join(conds, " and ")+")")
+	}
+	if len(ruleFilters) == 0 {
+		return ""
+	}
+	return strings.Join(ruleFilters, " or ")
+}
+
+// graphCondition translates cond into a KQL predicate which is true at least for all the resources matching cond.
+// Comparisons of resource groups are case insensitive in Resource Graph, so negations of them are not translated
+func graphCondition(cond rules.ConditionItem) (string, bool) {
+	tag := "tags[" + kqlString(cond.GetString("tag")) + "]"
+	switch cond.GetType() {
+	case "tagEqual":
+		return "tostring(" + tag + ") == " + kqlString(cond.GetString("value")), true
+	case "tagNotEqual":
+		return "isnotnull(" + tag + ") and tostring(" + tag + ") != " + kqlString(cond.GetString("value")), true
+	case "tagExists":
+		return "isnotnull(" + tag + ")", true
+	case "tagNotExists":
+		return "isnull(" + tag + ")", true
+	case "tagValueIn":
+		if values := cond.GetStrings("values"); len(values) > 0 {
+			return "tostring(" + tag + ") in (" + kqlList(values) + ")", true
+		}
+	case "regionEqual":
+		return "location =~ " + kqlString(cond.GetString("region")), true
+	case "regionIn":
+		if regions := cond.GetStrings("regions"); len(regions) > 0 {
+			return "location in~ (" + kqlList(regions) + ")", true
+		}
+	case "rgEqual":
+		return "resourceGroup =~ " + kqlString(cond.GetString("resourceGroup")), true
+	case "rgIn":
+		if groups := cond.GetStrings("resourceGroups"); len(groups) > 0 {
+			return "resourceGroup in~ (" + kqlList(groups) + ")", true
+		}
+	case "resEqual":
+		return "name =~ " + kqlString(cond.GetString("resource")), true
+	}
+	return "", false
+}
+
+// graphScope returns a KQL predicate of the resources in the scopes, so that Resource Graph doesn't return the others
+func graphScope(scopes rules.Scopes) string {
+	var preds []string
+	for _, scope := range scopes {
+		for _, f := range []struct {
+			column           string
+			include, exclude []string
+		}{
+			{"resourceGroup", scope.ResourceGroups, scope.ExcludeResourceGroups},
+			{"type", scope.Types, scope.ExcludeTypes},
+			{"location", scope.Regions, scope.ExcludeRegions},
+		} {
+			if len(f.include) > 0 {
+				preds = append(preds, f.column+" matches regex "+kqlString(globRegexp(f.include)))
+			}
+			if len(f.exclude) > 0 {
+				preds = append(preds, "not("+f.column+" matches regex "+kqlString(globRegexp(f.exclude))+")")
+			}
+		}
+	}
+	return strings.Join(preds, " and ")
+}
+
+// globRegexp returns a case insensitive regular expression matching any of the glob patterns
+func globRegexp(patterns []string) string {
+	alts := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		var b strings.Builder
+		for _, c := range pattern {
+			switch c {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		alts[i] = b.String()
+	}
+	return "(?i)^(" + strings.Join(alts, "|") + ")$"
+}
+
+// kqlString returns s as a KQL string literal
+func kqlString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func kqlList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = kqlString(s)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func graphString(row map[string]interface{}, column string) string {
+	if v, ok := row[column]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func graphTags(v interface{}) map[string]*string {
+	values, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	tags := make(map[string]*string, len(values))
+	for k, v := range values {
+		tags[k] = String(fmt.Sprint(v))
+	}
+	return tags
+}
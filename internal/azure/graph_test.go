@@ -0,0 +1,132 @@
+package azure
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resourcegraph/mgmt/2019-04-01/resourcegraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/mocks"
+)
+
+func TestGraphFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []rules.Rule
+		want  string
+	}{
+		{
+			name: "rules are or-ed, conditions and-ed",
+			rules: []rules.Rule{
+				{Conditions: []rules.ConditionItem{{"type": "tagEqual", "tag": "env", "value": "prod"}, {"type": "regionEqual", "region": "westeurope"}}},
+				{Conditions: []rules.ConditionItem{{"type": "rgIn", "resourceGroups": []interface{}{"a", "b"}}, {"type": "tagNotExists", "tag": "owner"}}},
+			},
+			want: `(tostring(tags['env']) == 'prod' and location =~ 'westeurope') or (resourceGroup in~ ('a', 'b') and isnull(tags['owner']))`,
+		},
+		{
+			name: "untranslated conditions are skipped",
+			rules: []rules.Rule{
+				{Conditions: []rules.ConditionItem{{"type": "rgNotEqual", "resourceGroup": "a"}, {"type": "resEqual", "resource": "it's"}}},
+			},
+			want: `(name =~ 'it\'s')`,
+		},
+		{
+			name: "a rule without translated conditions matches everything",
+			rules: []rules.Rule{
+				{Conditions: []rules.ConditionItem{{"type": "tagExists", "tag": "env"}}},
+				{Conditions: []rules.ConditionItem{{"type": "noTags"}}},
+			},
+			want: "",
+		},
+		{
+			name: "disabled rules are ignored",
+			rules: []rules.Rule{
+				{Conditions: []rules.ConditionItem{{"type": "tagExists", "tag": "env"}}},
+				{Mode: rules.ModeDisabled},
+			},
+			want: `(isnotnull(tags['env']))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, GraphFilter(rules.TagRules{Rules: tt.rules}))
+		})
+	}
+}
+
+func TestGraphScope(t *testing.T) {
+	scopes := rules.Scopes{
+		{ResourceGroups: []string{"prod-*", "shared"}, ExcludeTypes: []string{"Microsoft.Network/*"}},
+		{Regions: []string{"west?urope"}},
+	}
+	want := `resourceGroup matches regex '(?i)^(prod-.*|shared)$' and not(type matches regex '(?i)^(Microsoft\\.Network/.*)$') and location matches regex '(?i)^(west.urope)$'`
+	assert.Equal(t, want, graphScope(scopes))
+	assert.Equal(t, "", graphScope(nil))
+
+	re := regexp.MustCompile(globRegexp([]string{"prod-*", "shared"}))
+	assert.True(t, re.MatchString("PROD-web"))
+	assert.True(t, re.MatchString("shared"))
+	assert.False(t, re.MatchString("shared-2"))
+}
+
+func TestGraphScanner_GetResources(t *testing.T) {
+	client := new(mocks.BaseClientAPI)
+	isQuery := func(prefix string, skipToken string) interface{} {
+		return mock.MatchedBy(func(req resourcegraph.QueryRequest) bool {
+			token := ""
+			if req.Options.SkipToken != nil {
+				token = *req.Options.SkipToken
+			}
+			return strings.HasPrefix(*req.Query, prefix) && token == skipToken
+		})
+	}
+	client.On("Resources", context.Background(), isQuery(graphGroupsQuery, "")).Return(resourcegraph.QueryResponse{
+		Data: []interface{}{map[string]interface{}{"subscriptionId": "s1", "name": "MyGroup", "tags": map[string]interface{}{"env": "prod"}}},
+	}, nil)
+	client.On("Resources", context.Background(), isQuery("Resources | where isnotnull(tags['env'])", "")).Return(resourcegraph.QueryResponse{
+		Data: []interface{}{map[string]interface{}{
+			"id": "/subscriptions/s1/resourceGroups/MyGroup/providers/x/y/vm1", "name": "vm1", "location": "westeurope",
+			"resourceGroup": "mygroup", "subscriptionId": "s1", "tags": map[string]interface{}{"env": "prod"},
+			"type": "x/y", "kind": "", "sku": map[string]interface{}{"name": "Standard_B2s", "tier": "Standard"}, "managedBy": "",
+			"identityType": "SystemAssigned", "plan": "", "provisioningState": "Succeeded",
+		}},
+		SkipToken: String("page2"),
+	}, nil)
+	client.On("Resources", context.Background(), isQuery("Resources | where isnotnull(tags['env'])", "page2")).Return(resourcegraph.QueryResponse{
+		Data: []interface{}{map[string]interface{}{
+			"id": "/subscriptions/s1/resourceGroups/other/providers/x/y/vm2", "name": "vm2", "location": "westeurope",
+			"resourceGroup": "other", "subscriptionId": "s1", "tags": nil, "type": "x/y", "sku": nil,
+		}},
+	}, nil)
+
+	scanner := GraphScanner{
+		Client:           client,
+		Subscriptions:    []string{"s1"},
+		ManagementGroups: map[string][]string{"s1": {"root"}},
+		Filter:           "isnotnull(tags['env'])",
+	}
+	res, err := scanner.GetResources()
+	assert.Nil(t, err)
+	assert.Equal(t, []Resource{
+		{
+			Platform: "azure", ID: "/subscriptions/s1/resourceGroups/MyGroup/providers/x/y/vm1", Name: String("vm1"), Region: "westeurope",
+			Kind: String(""), Type: String("x/y"), Tags: map[string]*string{"env": String("prod")}, ResourceGroup: String("MyGroup"), Subscription: "s1",
+			ManagementGroups: []string{"root"}, SKU: "Standard_B2s", SKUTier: "Standard", IdentityType: "SystemAssigned", ProvisioningState: "Succeeded",
+		},
+		{
+			Platform: "azure", ID: "/subscriptions/s1/resourceGroups/other/providers/x/y/vm2", Name: String("vm2"), Region: "westeurope",
+			Kind: String(""), Type: String("x/y"), ResourceGroup: String("other"), Subscription: "s1", ManagementGroups: []string{"root"},
+		},
+	}, res)
+	assert.Equal(t, []string{FieldManagedBy, FieldIdentity, FieldManagementGroups}, scanner.Fields(), "Resource Graph has no times")
+
+	groups, err := scanner.ListGroups()
+	assert.Nil(t, err)
+	assert.Equal(t, []ResourceGroup{{Subscription: "s1", Name: "MyGroup", Tags: map[string]*string{"env": String("prod")}}}, groups)
+	client.AssertExpectations(t)
+}
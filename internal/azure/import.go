@@ -0,0 +1,250 @@
+package azure
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Formats of inventories exported by other tools, which can be imported as snapshots
+const (
+	InputAzJSON = "az-json" // output of az resource list -o json
+	InputArgCSV = "arg-csv" // CSV export of an Azure Resource Graph query
+)
+
+// InputFormats are the formats of inventories that can be imported
+var InputFormats = []string{InputAzJSON, InputArgCSV}
+
+// argColumns are the columns of Resource Graph exports that are read, by lower case name
+var argColumns = map[string]string{
+	"id":                "id",
+	"name":              "name",
+	"type":              "type",
+	"kind":              "kind",
+	"location":          "location",
+	"resourcegroup":     "resourceGroup",
+	"subscriptionid":    "subscriptionId",
+	"tags":              "tags",
+	"sku":               "sku",
+	"managedby":         "managedBy",
+	"identity":          "identity",
+	"identitytype":      "identityType",
+	"plan":              "plan",
+	"properties":        "properties",
+	"provisioningstate": "provisioningState",
+	"createdtime":       "createdTime",
+	"changedtime":       "changedTime",
+}
+
+// argJSONColumns are the columns of Resource Graph exports holding JSON objects or arrays. Their values can also be
+// plain strings, like the names of a projection
+var argJSONColumns = map[string]bool{"tags": true, "sku": true, "identity": true, "plan": true, "properties": true}
+
+// importFields are the optional fields of resources, see CheckFields, and the columns of exports filling them
+var importFields = []struct {
+	field   string
+	columns []string
+}{
+	{FieldManagedBy, []string{"managedBy"}},
+	{FieldIdentity, []string{"identity", "identityType"}},
+	{FieldCreatedTime, []string{"createdTime"}},
+	{FieldChangedTime, []string{"changedTime"}},
+}
+
+// filledFields returns the optional fields of resources filled by an export, which has the columns has returns
+// true for
+func filledFields(has func(column string) bool) []string {
+	var fields []string
+	for _, f := range importFields {
+		for _, column := range f.columns {
+			if has(column) {
+				fields = append(fields, f.field)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// ImportSnapshot reads the resources exported in format to the file named filename, and returns them as a snapshot.
+// Exports have no tags of resource groups, so the resource groups of the snapshot are the ones of the resources,
+// without tags
+func ImportSnapshot(format, filename string) (*Snapshot, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read inventory %s", filename)
+	}
+	defer f.Close()
+
+	var (
+		resources []Resource
+		fields    []string
+	)
+	switch format {
+	case InputAzJSON:
+		resources, fields, err = ReadAzJSON(f)
+	case InputArgCSV:
+		resources, fields, err = ReadArgCSV(f)
+	default:
+		return nil, errors.Errorf("unknown input format %q, expected one of %s", format, strings.Join(InputFormats, ", "))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't import inventory %s", filename)
+	}
+
+	metadata := SnapshotMetadata{Version: SnapshotVersion, Scanner: format, Fields: fields}
+	if info, err := f.Stat(); err == nil {
+		metadata.CreatedAt = info.ModTime().UTC()
+	}
+	snapshot := &Snapshot{Metadata: metadata, ResourceGroups: make([]SnapshotGroup, 0), Resources: make([]ResourceDocument, len(resources))}
+	groups, subs := make(map[string]bool), make(map[string]bool)
+	for i := range resources {
+		doc := NewResourceDocument(&resources[i])
+		snapshot.Resources[i] = doc
+		// resource groups of different subscriptions can have the same name
+		if rg := strings.ToLower(doc.Subscription + "/" + doc.ResourceGroup); doc.ResourceGroup != "" && !groups[rg] {
+			groups[rg] = true
+			snapshot.ResourceGroups = append(snapshot.ResourceGroups, SnapshotGroup{Subscription: doc.Subscription, Name: doc.ResourceGroup, Tags: map[string]string{}})
+		}
+		if sub := strings.ToLower(doc.Subscription); sub != "" && !subs[sub] {
+			subs[sub] = true
+			snapshot.Metadata.Subscriptions = append(snapshot.Metadata.Subscriptions, doc.Subscription)
+		}
+	}
+	return snapshot, nil
+}
+
+// ReadAzJSON reads the resources listed by az resource list -o json, and returns them with the optional fields of
+// resources that every listed resource has, even if null
+func ReadAzJSON(r io.Reader) ([]Resource, []string, error) {
+	var rows []map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, nil, errors.Wrap(err, "can't decode resource list")
+	}
+	tab := make([]Resource, 0, len(rows))
+	for i, row := range rows {
+		res, err := importedResource(row)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "resource %d", i)
+		}
+		tab = append(tab, res)
+	}
+	fields := filledFields(func(column string) bool {
+		for _, row := range rows {
+			if _, ok := row[column]; !ok {
+				return false
+			}
+		}
+		return true
+	})
+	return tab, fields, nil
+}
+
+// ReadArgCSV reads the resources of a CSV export of a Resource Graph query. The header names the columns, which are
+// the ones of the resources table, like id, name, type, location, tags and sku. Objects and arrays, like tags, are
+// JSON. The resources are returned with the optional fields of resources that the export has columns for
+func ReadArgCSV(r io.Reader) ([]Resource, []string, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't read header")
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = argColumns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))]
+	}
+	fields := filledFields(func(column string) bool { return contains(columns, column) })
+
+	tab := make([]Resource, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return tab, fields, nil
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't read row")
+		}
+		row := make(map[string]interface{}, len(record))
+		for i, value := range record {
+			if columns[i] == "" || value == "" {
+				continue
+			}
+			row[columns[i]] = value
+			if argJSONColumns[columns[i]] && (strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[")) {
+				var object interface{}
+				if err := json.Unmarshal([]byte(value), &object); err != nil {
+					return nil, nil, errors.Wrapf(err, "line %d: invalid %s", line, columns[i])
+				}
+				row[columns[i]] = object
+			}
+		}
+		res, err := importedResource(row)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "line %d", line)
+		}
+		tab = append(tab, res)
+	}
+}
+
+// importedResource returns the resource of a row of an export, with the fields of az resource list and of the
+// resources table of Resource Graph
+func importedResource(row map[string]interface{}) (Resource, error) {
+	id := graphString(row, "id")
+	if id == "" {
+		return Resource{}, errors.New("id is missing")
+	}
+	if _, ok := row["tags"].(map[string]interface{}); !ok && row["tags"] != nil {
+		return Resource{}, errors.Errorf("invalid tags %v, expected an object", row["tags"])
+	}
+	res := Resource{
+		Platform:          "azure",
+		ID:                id,
+		Name:              String(graphString(row, "name")),
+		Region:            graphString(row, "location"),
+		Kind:              String(graphString(row, "kind")),
+		Type:              String(graphString(row, "type")),
+		Tags:              graphTags(row["tags"]),
+		ResourceGroup:     String(graphString(row, "resourceGroup")),
+		Subscription:      graphString(row, "subscriptionId"),
+		SKU:               graphString(row, "sku"),
+		ManagedBy:         graphString(row, "managedBy"),
+		IdentityType:      graphString(row, "identityType"),
+		Plan:              graphString(row, "plan"),
+		ProvisioningState: graphString(row, "provisioningState"),
+	}
+	if *res.ResourceGroup == "" {
+		res.ResourceGroup = String(resourceGroupFromID(id))
+	}
+	if sku, ok := row["sku"].(map[string]interface{}); ok {
+		res.SKU, res.SKUTier = graphString(sku, "name"), graphString(sku, "tier")
+	}
+	if identity, ok := row["identity"].(map[string]interface{}); ok {
+		res.IdentityType = graphString(identity, "type")
+	}
+	if plan, ok := row["plan"].(map[string]interface{}); ok {
+		res.Plan = graphString(plan, "name")
+	}
+	if properties, ok := row["properties"].(map[string]interface{}); ok && res.ProvisioningState == "" {
+		res.ProvisioningState = graphString(properties, "provisioningState")
+	}
+
+	for _, field := range []struct {
+		column string
+		value  **time.Time
+	}{{"createdTime", &res.CreatedTime}, {"changedTime", &res.ChangedTime}} {
+		if s := graphString(row, field.column); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return Resource{}, errors.Wrapf(err, "invalid %s", field.column)
+			}
+			t = t.UTC()
+			*field.value = &t
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const azResourceList = `[
+  {
+    "id": "/subscriptions/sub1/resourceGroups/RG1/providers/Microsoft.Compute/disks/disk1",
+    "name": "disk1",
+    "type": "Microsoft.Compute/disks",
+    "kind": null,
+    "location": "westeurope",
+    "resourceGroup": "RG1",
+    "managedBy": "/subscriptions/sub1/resourceGroups/RG1/providers/Microsoft.Compute/virtualMachines/vm1",
+    "sku": {"name": "Premium_LRS", "tier": "Premium"},
+    "identity": null,
+    "plan": null,
+    "tags": {"env": "prod"},
+    "createdTime": "2021-01-01T10:00:00.123456+00:00",
+    "provisioningState": "Succeeded"
+  },
+  {
+    "id": "/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Web/sites/app",
+    "name": "app",
+    "type": "Microsoft.Web/sites",
+    "kind": "app",
+    "location": "northeurope",
+    "managedBy": null,
+    "identity": {"type": "SystemAssigned"},
+    "tags": null
+  }
+]`
+
+const argExport = "\ufeffID,NAME,TYPE,LOCATION,RESOURCEGROUP,SUBSCRIPTIONID,TAGS,SKU,PROPERTIES,OTHER\n" +
+	`/subscriptions/sub2/resourceGroups/rg2/providers/Microsoft.Storage/storageAccounts/sa,sa,microsoft.storage/storageaccounts,westeurope,rg2,sub2,"{""env"":""dev""}","{""name"":""Standard_LRS"",""tier"":""Standard""}","{""provisioningState"":""Failed""}",x` + "\n"
+
+func TestReadAzJSON(t *testing.T) {
+	res, fields, err := ReadAzJSON(strings.NewReader(azResourceList))
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, []string{FieldManagedBy, FieldIdentity}, fields, "createdTime is not listed for every resource")
+
+	created := time.Date(2021, 1, 1, 10, 0, 0, 123456000, time.UTC)
+	assert.Equal(t, Resource{
+		Platform:          "azure",
+		ID:                "/subscriptions/sub1/resourceGroups/RG1/providers/Microsoft.Compute/disks/disk1",
+		Name:              String("disk1"),
+		Region:            "westeurope",
+		Kind:              String(""),
+		Type:              String("Microsoft.Compute/disks"),
+		Tags:              map[string]*string{"env": String("prod")},
+		ResourceGroup:     String("RG1"),
+		SKU:               "Premium_LRS",
+		SKUTier:           "Premium",
+		ManagedBy:         "/subscriptions/sub1/resourceGroups/RG1/providers/Microsoft.Compute/virtualMachines/vm1",
+		ProvisioningState: "Succeeded",
+		CreatedTime:       &created,
+	}, res[0])
+	assert.Equal(t, "rg1", *res[1].ResourceGroup)
+	assert.Equal(t, "sub1", res[1].SubscriptionID())
+	assert.Equal(t, "SystemAssigned", res[1].IdentityType)
+	assert.Nil(t, res[1].Tags)
+
+	_, _, err = ReadAzJSON(strings.NewReader(`[{"name": "noid"}]`))
+	assert.EqualError(t, err, "resource 0: id is missing")
+}
+
+func TestReadArgCSV(t *testing.T) {
+	res, fields, err := ReadArgCSV(strings.NewReader(argExport))
+	assert.Nil(t, err)
+	assert.Nil(t, fields)
+	assert.Equal(t, []Resource{{
+		Platform:          "azure",
+		ID:                "/subscriptions/sub2/resourceGroups/rg2/providers/Microsoft.Storage/storageAccounts/sa",
+		Name:              String("sa"),
+		Region:            "westeurope",
+		Kind:              String(""),
+		Type:              String("microsoft.storage/storageaccounts"),
+		Tags:              map[string]*string{"env": String("dev")},
+		ResourceGroup:     String("rg2"),
+		Subscription:      "sub2",
+		SKU:               "Standard_LRS",
+		SKUTier:           "Standard",
+		ProvisioningState: "Failed",
+	}}, res)
+
+	_, _, err = ReadArgCSV(strings.NewReader("id,tags\n/subscriptions/sub2/x,{bad\n"))
+	assert.Error(t, err)
+	_, _, err = ReadArgCSV(strings.NewReader("id,tags\n/subscriptions/sub2/x,\"[\"\"env\"\"]\"\n"))
+	assert.EqualError(t, err, "line 2: invalid tags [env], expected an object", "arrays are decoded")
+	_, _, err = ReadArgCSV(strings.NewReader("id,tags\n/subscriptions/sub2/x,env=prod\n"))
+	assert.EqualError(t, err, "line 2: invalid tags env=prod, expected an object")
+
+	res, _, err = ReadArgCSV(strings.NewReader("id,sku\n/subscriptions/sub2/x,Basic\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Basic", res[0].SKU, "projected names stay strings")
+}
+
+func TestImportSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "resources.json")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(azResourceList), 0600))
+
+	snapshot, err := ImportSnapshot(InputAzJSON, filename)
+	assert.Nil(t, err)
+	assert.Equal(t, InputAzJSON, snapshot.Metadata.Scanner)
+	assert.Equal(t, []string{"sub1"}, snapshot.Metadata.Subscriptions)
+	assert.Equal(t, []string{FieldManagedBy, FieldIdentity}, snapshot.Metadata.Fields)
+	assert.Equal(t, []SnapshotGroup{{Subscription: "sub1", Name: "RG1", Tags: map[string]string{}}}, snapshot.ResourceGroups)
+
+	res, err := SnapshotScanner{Snapshot: snapshot}.GetResourcesByResourceGroup("rg1")
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+
+	// resource groups of different subscriptions are kept apart
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(`[
+  {"id": "/subscriptions/a/resourceGroups/NetworkWatcherRG/providers/x/y/w"},
+  {"id": "/subscriptions/b/resourceGroups/networkwatcherrg/providers/x/y/w"}
+]`), 0600))
+	snapshot, err = ImportSnapshot(InputAzJSON, filename)
+	assert.Nil(t, err)
+	assert.Equal(t, []SnapshotGroup{
+		{Subscription: "a", Name: "NetworkWatcherRG", Tags: map[string]string{}},
+		{Subscription: "b", Name: "networkwatcherrg", Tags: map[string]string{}},
+	}, snapshot.ResourceGroups)
+
+	_, err = ImportSnapshot("xml", filename)
+	assert.EqualError(t, err, `unknown input format "xml", expected one of az-json, arg-csv`)
+}
@@ -0,0 +1,63 @@
+package azure
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Pipeline evaluates the rules on the resources streamed by scanner and executes the actions of each matched
+// resource as soon as it is scanned, instead of after the whole scan. matched, if not nil, is called with each
+// matched resource before its actions are executed, for example to back it up, and executed with the executions
+// of its actions. Either stops the pipeline by returning an error. Neither the matched resources nor the executions
+// are kept, and scanning waits while the actions are executed once streamBuffer resources are waiting, so memory
+// doesn't grow with the number of resources scanned. The summary of the executions done before a failure is
+// returned with the error
+func (t *Tagger) Pipeline(ctx context.Context, scanner ResourceStreamer, matched func(Matched) error, executed func([]ActionExecution) error) (Summary, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in := make(chan Resource, streamBuffer)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- scanner.StreamResources(ctx, in)
+		close(in)
+	}()
+	// stop stops the scan after a failure, and waits for the scanner to return
+	stop := func() {
+		cancel()
+		for range in {
+		}
+		<-errc
+	}
+
+	summary := Summarize(nil)
+	for resource := range in {
+		m, ok := t.EvaluateResource(resource)
+		if !ok {
+			continue
+		}
+
+		if matched != nil {
+			if err := matched(m); err != nil {
+				stop()
+				return summary, err
+			}
+		}
+		executions, err := t.executeMatched(resource.ID, m)
+		summary.AddResource(executions)
+		if executed != nil {
+			if cerr := executed(executions); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			stop()
+			return summary, err
+		}
+	}
+	if err := <-errc; err != nil {
+		return summary, errors.Wrap(err, "can't scan resources")
+	}
+	return summary, nil
+}
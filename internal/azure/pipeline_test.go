@@ -0,0 +1,91 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/nordcloud/azure-tag-manager/mocks"
+)
+
+// sliceStreamer streams a list of resources, then fails with err
+type sliceStreamer struct {
+	Scanner
+	resources []Resource
+	err       error
+}
+
+func (s sliceStreamer) StreamResources(ctx context.Context, out chan<- Resource) error {
+	for _, res := range s.resources {
+		if err := send(ctx, out, res); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func TestTagger_Pipeline(t *testing.T) {
+	newTagger := func(client *mocks.ClientAPI) *Tagger {
+		tagger := &Tagger{
+			ResourcesClient: client,
+			Rules:           twoRulesWant,
+			Matched:         make(map[string]Matched),
+		}
+		tagger.InitActionMap()
+		tagger.InitCondMap()
+		return tagger
+	}
+
+	t.Run("matched resources are passed on before their actions, and their executions after", func(t *testing.T) {
+		var events []string
+		mockClient := new(mocks.ClientAPI)
+		mockClient.On("GetByID", context.Background(), "1").Return(resources.GenericResource{ID: String("1")}, nil).Run(func(mock.Arguments) {
+			events = append(events, "execute")
+		})
+		mockClient.On("UpdateByID", context.Background(), "1", resources.GenericResource{Tags: map[string]*string{"test2": String("test2")}}).Return(resources.UpdateByIDFuture{}, nil)
+
+		tagger := newTagger(mockClient)
+		summary, err := tagger.Pipeline(context.Background(), sliceStreamer{resources: testResources}, func(m Matched) error {
+			events = append(events, "matched "+m.Resource.ID)
+			return nil
+		}, func(ael []ActionExecution) error {
+			events = append(events, fmt.Sprintf("executed %d", len(ael)))
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, summary.Resources)
+		assert.Equal(t, 1, summary.Executions)
+		assert.Equal(t, []string{"matched 1", "execute", "executed 1"}, events)
+		assert.Empty(t, tagger.Matched, "matched resources aren't kept")
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("scan failures are returned with the summary of the executions", func(t *testing.T) {
+		mockClient := new(mocks.ClientAPI)
+		mockClient.On("GetByID", context.Background(), "1").Return(resources.GenericResource{ID: String("1")}, nil)
+		mockClient.On("UpdateByID", context.Background(), "1", resources.GenericResource{Tags: map[string]*string{"test2": String("test2")}}).Return(resources.UpdateByIDFuture{}, nil)
+
+		summary, err := newTagger(mockClient).Pipeline(context.Background(), sliceStreamer{resources: testResources, err: errors.New("throttled")}, nil, nil)
+		assert.EqualError(t, err, "can't scan resources: throttled")
+		assert.Equal(t, 1, summary.Executions)
+	})
+
+	t.Run("a failing callback stops the pipeline", func(t *testing.T) {
+		mockClient := new(mocks.ClientAPI)
+		many := make([]Resource, 0, 10*streamBuffer)
+		for i := 0; i < 10*streamBuffer; i++ {
+			many = append(many, testResources...)
+		}
+		summary, err := newTagger(mockClient).Pipeline(context.Background(), sliceStreamer{resources: many}, func(m Matched) error {
+			return errors.New("disk full")
+		}, nil)
+		assert.EqualError(t, err, "disk full")
+		assert.Zero(t, summary.Executions)
+		mockClient.AssertNumberOfCalls(t, "UpdateByID", 0)
+	})
+}
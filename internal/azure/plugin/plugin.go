@@ -0,0 +1,331 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+// ProtocolVersion is the version of the protocol spoken with plugins
+const ProtocolVersion = 1
+
+// DefaultTimeout is the time a plugin has to answer a request
+const DefaultTimeout = 10 * time.Second
+
+// Plugin is a running plugin executable. Requests and responses are JSON objects, one per line, on the standard
+// input and output of the plugin
+type Plugin struct {
+	Name       string
+	Path       string
+	Conditions []rules.Spec
+	Actions    []rules.Spec
+	Timeout    time.Duration
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan response
+	closed    chan struct{}
+	readers   sync.WaitGroup // reading the standard output and error, which must end before cmd.Wait
+	lastID    int
+	broken    error
+}
+
+type request struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	err error // reading the response failed
+}
+
+type handshakeParams struct {
+	ProtocolVersion int `json:"protocolVersion"`
+}
+
+type handshakeResult struct {
+	ProtocolVersion int          `json:"protocolVersion"`
+	Conditions      []rules.Spec `json:"conditions"`
+	Actions         []rules.Spec `json:"actions"`
+}
+
+type evaluateParams struct {
+	Condition rules.ConditionItem    `json:"condition"`
+	Resource  azure.ResourceDocument `json:"resource"`
+}
+
+type evaluateResult struct {
+	Match bool `json:"match"`
+}
+
+type executeParams struct {
+	Action   rules.ActionItem       `json:"action"`
+	Resource azure.ResourceDocument `json:"resource"`
+}
+
+type executeResult struct {
+	Set    map[string]string `json:"set"`
+	Delete []string          `json:"delete"`
+}
+
+// Discover returns the executables in dir, sorted by name
+func Discover(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read plugin directory")
+	}
+	var paths []string
+	for _, file := range files {
+		if file.Mode().IsRegular() && file.Mode().Perm()&0111 != 0 {
+			paths = append(paths, filepath.Join(dir, file.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Start starts the plugin executable path and does the handshake, which tells the conditions and actions of the plugin
+func Start(path string, timeout time.Duration) (*Plugin, error) {
+	p := &Plugin{
+		Name:      filepath.Base(path),
+		Path:      path,
+		Timeout:   timeout,
+		cmd:       exec.Command(path),
+		responses: make(chan response),
+		closed:    make(chan struct{}),
+	}
+
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't start plugin %s", p.Name)
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't start plugin %s", p.Name)
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't start plugin %s", p.Name)
+	}
+	p.stdin = stdin
+	if err := p.cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "can't start plugin %s", p.Name)
+	}
+	p.readers.Add(2)
+	go p.read(stdout)
+	go p.log(stderr)
+
+	var result handshakeResult
+	if err := p.call("handshake", handshakeParams{ProtocolVersion: ProtocolVersion}, &result); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if result.ProtocolVersion != ProtocolVersion {
+		p.Close()
+		return nil, errors.Errorf("plugin %s speaks protocol version %d, expected %d", p.Name, result.ProtocolVersion, ProtocolVersion)
+	}
+	p.Conditions, p.Actions = result.Conditions, result.Actions
+	return p, nil
+}
+
+// read passes the responses of the plugin to call
+func (p *Plugin) read(stdout io.Reader) {
+	defer p.readers.Done()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			resp.err = errors.Wrap(err, "invalid response")
+		}
+		if !p.send(resp) {
+			return
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	p.send(response{err: errors.Wrap(err, "plugin exited")})
+}
+
+// send passes resp to call, unless the plugin is closed
+func (p *Plugin) send(resp response) bool {
+	select {
+	case p.responses <- resp:
+		return true
+	case <-p.closed:
+		return false
+	}
+}
+
+// log logs what the plugin writes to its standard error
+func (p *Plugin) log(stderr io.Reader) {
+	defer p.readers.Done()
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Infof("Plugin %s: %s", p.Name, scanner.Text())
+	}
+}
+
+// call sends a request to the plugin and decodes the result into result. A plugin which doesn't answer
+// in time is stopped, as its responses can't be matched to requests anymore
+func (p *Plugin) call(method string, params, result interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.broken != nil {
+		return errors.Wrapf(p.broken, "plugin %s", p.Name)
+	}
+
+	p.lastID++
+	req := request{ID: p.lastID, Method: method, Params: params}
+	dat, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "plugin %s: can't encode %s request", p.Name, method)
+	}
+	if _, err := p.stdin.Write(append(dat, '\n')); err != nil {
+		p.broken = errors.Wrap(err, "can't send request")
+		return errors.Wrapf(p.broken, "plugin %s", p.Name)
+	}
+
+	timer := time.NewTimer(p.Timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-p.responses:
+		switch {
+		case resp.err != nil:
+			p.broken = resp.err
+		case resp.ID != req.ID:
+			p.broken = errors.Errorf("response to request %d, expected %d", resp.ID, req.ID)
+		case resp.Error != nil:
+			return errors.Errorf("plugin %s: %s failed: %s", p.Name, method, resp.Error.Message)
+		default:
+			if result == nil {
+				return nil
+			}
+			return errors.Wrapf(json.Unmarshal(resp.Result, result), "plugin %s: invalid %s result", p.Name, method)
+		}
+	case <-timer.C:
+		p.broken = errors.Errorf("%s timed out after %s", method, p.Timeout)
+		p.cmd.Process.Kill()
+	}
+	return errors.Wrapf(p.broken, "plugin %s", p.Name)
+}
+
+// Evaluate evaluates the condition cond of the plugin on data
+func (p *Plugin) Evaluate(cond rules.ConditionItem, data *azure.Resource) (bool, error) {
+	var result evaluateResult
+	if err := p.call("evaluate", evaluateParams{Condition: cond, Resource: azure.NewResourceDocument(data)}, &result); err != nil {
+		return false, err
+	}
+	return result.Match, nil
+}
+
+// Execute executes the action of the plugin on data and returns the tag changes
+func (p *Plugin) Execute(action rules.ActionItem, data *azure.Resource) (azure.TagChanges, error) {
+	var result executeResult
+	if err := p.call("execute", executeParams{Action: action, Resource: azure.NewResourceDocument(data)}, &result); err != nil {
+		return azure.TagChanges{}, err
+	}
+	return azure.TagChanges{Set: result.Set, Delete: result.Delete}, nil
+}
+
+// Close closes the standard input of the plugin, which tells it to exit, and waits for it. The outputs of the plugin
+// are read to the end before waiting, as cmd.Wait closes them
+func (p *Plugin) Close() error {
+	select {
+	case <-p.closed:
+		return nil
+	default:
+		close(p.closed)
+	}
+	p.stdin.Close()
+	done := make(chan error, 1)
+	go func() {
+		p.readers.Wait()
+		done <- p.cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(p.Timeout):
+		p.cmd.Process.Kill()
+		return errors.Errorf("plugin %s did not exit, killed", p.Name)
+	}
+}
+
+// register registers the conditions and actions of the plugin
+func (p *Plugin) register() error {
+	for _, spec := range p.Conditions {
+		spec := spec
+		err := azure.RegisterCondition(spec, func(cond rules.ConditionItem, data *azure.Resource) bool {
+			match, err := p.Evaluate(cond, data)
+			if err != nil {
+				log.Warnf("Condition %s ignored on %s: %s", spec.Type, data.ID, err)
+				return false
+			}
+			return match
+		})
+		if err != nil {
+			return errors.Wrapf(err, "plugin %s", p.Name)
+		}
+	}
+	for _, spec := range p.Actions {
+		if err := azure.RegisterAction(spec, p.Execute); err != nil {
+			return errors.Wrapf(err, "plugin %s", p.Name)
+		}
+	}
+	return nil
+}
+
+// Load starts the plugins in dir and registers their conditions and actions
+func Load(dir string, timeout time.Duration) ([]*Plugin, error) {
+	paths, err := Discover(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var plugins []*Plugin
+	for _, path := range paths {
+		p, err := Start(path, timeout)
+		if err != nil {
+			CloseAll(plugins)
+			return nil, err
+		}
+		if err := p.register(); err != nil {
+			CloseAll(append(plugins, p))
+			return nil, err
+		}
+		log.Infof("Plugin %s loaded with %d condition(s) and %d action(s)", p.Name, len(p.Conditions), len(p.Actions))
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// CloseAll closes plugins, logging errors
+func CloseAll(plugins []*Plugin) {
+	for _, p := range plugins {
+		if err := p.Close(); err != nil {
+			log.Warnf("Plugin %s: %s", p.Name, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHelperProcess is the plugin started by the tests
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     int    `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Condition map[string]string      `json:"condition"`
+				Action    map[string]string      `json:"action"`
+				Resource  azure.ResourceDocument `json:"resource"`
+			} `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &req)
+
+		var result interface{}
+		errMsg := ""
+		switch req.Method {
+		case "handshake":
+			result = map[string]interface{}{
+				"protocolVersion": ProtocolVersion,
+				"conditions": []map[string]interface{}{
+					{"type": "testNameIs", "params": []map[string]interface{}{{"name": "name", "type": "string", "required": true}}},
+					{"type": "testSleep"},
+				},
+				"actions": []map[string]interface{}{{"type": "testOwner"}, {"type": "testFail"}},
+			}
+		case "evaluate":
+			if req.Params.Condition["type"] == "testSleep" {
+				time.Sleep(time.Second)
+			}
+			result = map[string]bool{"match": req.Params.Resource.Name == req.Params.Condition["name"]}
+		case "execute":
+			if req.Params.Action["type"] == "testFail" {
+				errMsg = "failed on purpose"
+				break
+			}
+			fmt.Fprintf(os.Stderr, "tagging %s\n", req.Params.Resource.ID)
+			result = map[string]interface{}{"set": map[string]string{"owner": req.Params.Resource.Tags["team"]}, "delete": []string{"team"}}
+		}
+
+		resp := map[string]interface{}{"id": req.ID, "result": result}
+		if errMsg != "" {
+			resp = map[string]interface{}{"id": req.ID, "error": map[string]string{"message": errMsg}}
+		}
+		dat, _ := json.Marshal(resp)
+		fmt.Println(string(dat))
+	}
+}
+
+// helperDir returns a directory with an executable starting the test binary as a plugin
+func helperDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\nGO_WANT_HELPER_PROCESS=1 exec %q -test.run=TestHelperProcess\n", os.Args[0])
+	if err := ioutil.WriteFile(filepath.Join(dir, "test-plugin"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPlugin(t *testing.T) {
+	dir := helperDir(t)
+	defer os.RemoveAll(dir)
+
+	paths, err := Discover(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "test-plugin")}, paths)
+
+	p, err := Start(paths[0], 500*time.Millisecond)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer p.Close()
+	assert.Equal(t, []rules.Spec{
+		{Type: "testNameIs", Params: []rules.Param{{Name: "name", Type: rules.StringParam, Required: true}}},
+		{Type: "testSleep"},
+	}, p.Conditions)
+
+	resource := &azure.Resource{ID: "1", Name: azure.String("vm"), Tags: map[string]*string{"team": azure.String("platform")}}
+	match, err := p.Evaluate(rules.ConditionItem{"type": "testNameIs", "name": "vm"}, resource)
+	assert.Nil(t, err)
+	assert.True(t, match)
+
+	changes, err := p.Execute(rules.ActionItem{"type": "testOwner"}, resource)
+	assert.Nil(t, err)
+	assert.Equal(t, azure.TagChanges{Set: map[string]string{"owner": "platform"}, Delete: []string{"team"}}, changes)
+
+	_, err = p.Execute(rules.ActionItem{"type": "testFail"}, resource)
+	assert.EqualError(t, err, "plugin test-plugin: execute failed: failed on purpose")
+
+	_, err = p.Evaluate(rules.ConditionItem{"type": "testSleep"}, resource)
+	assert.EqualError(t, err, "plugin test-plugin: evaluate timed out after 500ms")
+	_, err = p.Evaluate(rules.ConditionItem{"type": "testNameIs", "name": "vm"}, resource)
+	assert.NotNil(t, err, "a plugin that timed out is stopped")
+}
+
+func TestLoad(t *testing.T) {
+	dir := helperDir(t)
+	defer os.RemoveAll(dir)
+	t.Cleanup(func() { azure.Unregister("testNameIs", "testSleep", "testOwner", "testFail") })
+
+	plugins, err := Load(dir, DefaultTimeout)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer CloseAll(plugins)
+	assert.Len(t, plugins, 1)
+
+	conditions, actions := azure.Specs()
+	assert.Contains(t, conditions, rules.Spec{Type: "testSleep"})
+	assert.Contains(t, actions, rules.Spec{Type: "testOwner"})
+
+	_, err = Load(dir, DefaultTimeout)
+	assert.NotNil(t, err, "types of a plugin can't be registered twice")
+}
@@ -0,0 +1,113 @@
+package azure
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+// ConditionFunc evaluates a condition p on the resource data
+type ConditionFunc func(p rules.ConditionItem, data *Resource) bool
+
+// ActionFunc computes the tag changes of an action p on the resource data. The tagger applies the changes
+type ActionFunc func(p rules.ActionItem, data *Resource) (TagChanges, error)
+
+// registry holds the custom types of conditions and actions, added to every tagger
+var registry = struct {
+	sync.Mutex
+	conditions map[string]condition
+	actions    map[string]ActionFunc
+	specs      map[string]rules.Spec // specs of actions
+}{
+	conditions: make(map[string]condition),
+	actions:    make(map[string]ActionFunc),
+	specs:      make(map[string]rules.Spec),
+}
+
+// RegisterCondition adds a custom type of conditions described by spec. It is an error to register a type twice,
+// or to register one of the built-in types
+func RegisterCondition(spec rules.Spec, eval ConditionFunc) error {
+	if spec.Type == "" || eval == nil {
+		return errors.New("a condition needs a type and a function")
+	}
+	t := Tagger{}
+	t.InitCondMap()
+	if _, ok := t.condMap[spec.Type]; ok {
+		return errors.Errorf("condition type %q is already registered", spec.Type)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.conditions[spec.Type]; ok {
+		return errors.Errorf("condition type %q is already registered", spec.Type)
+	}
+	registry.conditions[spec.Type] = condition{spec: spec, eval: eval}
+	return nil
+}
+
+// RegisterAction adds a custom type of actions described by spec. It is an error to register a type twice,
+// or to register one of the built-in types
+func RegisterAction(spec rules.Spec, execute ActionFunc) error {
+	if spec.Type == "" || execute == nil {
+		return errors.New("an action needs a type and a function")
+	}
+	t := Tagger{}
+	t.InitActionMap()
+	if _, ok := t.actionMap[spec.Type]; ok {
+		return errors.Errorf("action type %q is already registered", spec.Type)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.actions[spec.Type]; ok {
+		return errors.Errorf("action type %q is already registered", spec.Type)
+	}
+	registry.actions[spec.Type] = execute
+	registry.specs[spec.Type] = spec
+	return nil
+}
+
+// Unregister removes the custom types of conditions and actions named types
+func Unregister(types ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, name := range types {
+		delete(registry.conditions, name)
+		delete(registry.actions, name)
+		delete(registry.specs, name)
+	}
+}
+
+// addRegisteredConditions adds the registered conditions to the tagger
+func (t *Tagger) addRegisteredConditions() {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, cond := range registry.conditions {
+		t.addCondition(cond.spec, cond.eval)
+	}
+}
+
+// addRegisteredActions adds the registered actions to the tagger
+func (t *Tagger) addRegisteredActions() {
+	registry.Lock()
+	defer registry.Unlock()
+	for name, execute := range registry.actions {
+		t.addChangesAction(registry.specs[name], execute)
+	}
+}
+
+// addChangesAction adds an action which computes tag changes with execute, and applies them
+func (t *Tagger) addChangesAction(spec rules.Spec, execute ActionFunc) {
+	t.addAction(spec, func(p rules.ActionItem, data *Resource) (string, error) {
+		changes, err := execute(p, data)
+		if err != nil {
+			return "", errors.Wrapf(err, "Action %s failed for resource %s", spec.Type, data.ID)
+		}
+		if err := t.applyTagChanges(data.ID, changes); err != nil {
+			return "", errors.Wrapf(err, "Action %s failed for resource %s", spec.Type, data.ID)
+		}
+		return "", nil
+	})
+}
@@ -0,0 +1,57 @@
+package azure
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Cleanup(func() { Unregister("testNameHasPrefix", "testUpperName") })
+	prefixSpec := rules.Spec{
+		Type:   "testNameHasPrefix",
+		Params: []rules.Param{{Name: "prefix", Type: rules.StringParam, Required: true}},
+	}
+	err := RegisterCondition(prefixSpec, func(p rules.ConditionItem, data *Resource) bool {
+		return data.Name != nil && strings.HasPrefix(*data.Name, p.GetString("prefix"))
+	})
+	assert.Nil(t, err)
+	err = RegisterAction(rules.Spec{Type: "testUpperName"}, func(p rules.ActionItem, data *Resource) (TagChanges, error) {
+		return TagChanges{Set: map[string]string{"name": strings.ToUpper(*data.Name)}}, nil
+	})
+	assert.Nil(t, err)
+
+	assert.NotNil(t, RegisterCondition(prefixSpec, func(p rules.ConditionItem, data *Resource) bool { return true }), "registered twice")
+	assert.NotNil(t, RegisterCondition(rules.Spec{Type: "tagEqual"}, func(p rules.ConditionItem, data *Resource) bool { return true }), "built-in type")
+	assert.NotNil(t, RegisterAction(rules.Spec{Type: "addTag"}, func(p rules.ActionItem, data *Resource) (TagChanges, error) { return TagChanges{}, nil }), "built-in type")
+
+	conditions, actions := Specs()
+	assert.Contains(t, conditions, prefixSpec)
+	assert.Contains(t, actions, rules.Spec{Type: "testUpperName"})
+
+	ruleDef := rules.TagRules{Version: rules.Version2, Rules: []rules.Rule{
+		{Name: "custom", Conditions: []rules.ConditionItem{{"type": "testNameHasPrefix", "prefix": "name2"}}, Actions: []rules.ActionItem{{"type": "testUpperName"}}},
+	}}
+	assert.Nil(t, ValidateRules(ruleDef))
+
+	mockClient := new(mocks.ClientAPI)
+	mockClient.On("GetByID", context.Background(), "2").Return(resources.GenericResource{ID: String("2")}, nil)
+	mockClient.On("UpdateByID", context.Background(), "2", resources.GenericResource{Tags: map[string]*string{"name": String("NAME2")}}).Return(resources.UpdateByIDFuture{}, nil)
+	tagger := Tagger{
+		ResourcesClient: mockClient,
+		Rules:           ruleDef,
+		Matched:         make(map[string]Matched),
+	}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	tagger.EvaluateRules(testResources)
+	assert.Len(t, tagger.Matched, 1)
+	_, err = tagger.ExecuteActions()
+	assert.Nil(t, err)
+	mockClient.AssertExpectations(t)
+}
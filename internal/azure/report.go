@@ -0,0 +1,96 @@
+package azure
+
+import "sort"
+
+// NoGroup is the name of the group of executions whose rules do not have the grouping metadata set
+const NoGroup = "(none)"
+
+// GroupExecutions groups action executions by the metadata key of their rules (see rules.Rule.MetadataValue).
+// It returns the sorted names of the groups and the groups themselves.
+func GroupExecutions(ael []ActionExecution, key string) ([]string, map[string][]ActionExecution) {
+	return groupExecutions(ael, func(ae ActionExecution) string { return ae.Rule.MetadataValue(key) })
+}
+
+// GroupExecutionsByTag groups action executions by the value of the tag of their resources, like an owner tag,
+// as it was when the rules were evaluated. It returns the sorted names of the groups and the groups themselves.
+func GroupExecutionsByTag(ael []ActionExecution, tag string) ([]string, map[string][]ActionExecution) {
+	return groupExecutions(ael, func(ae ActionExecution) string { return ae.Tags[tag] })
+}
+
+func groupExecutions(ael []ActionExecution, groupOf func(ActionExecution) string) ([]string, map[string][]ActionExecution) {
+	groups := make(map[string][]ActionExecution)
+	for _, ae := range ael {
+		name := groupOf(ae)
+		if name == "" {
+			name = NoGroup
+		}
+		groups[name] = append(groups[name], ae)
+	}
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			if group[i].ResourceID != group[j].ResourceID {
+				return group[i].ResourceID < group[j].ResourceID
+			}
+			return group[i].RuleName < group[j].RuleName
+		})
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, groups
+}
+
+// Summary summarizes the executions of a run
+type Summary struct {
+	Resources     int            `json:"resources"`               // resources with at least one execution
+	Executions    int            `json:"executions"`              // rule executions
+	NonCompliant  int            `json:"nonCompliant"`            // executions of audit rules
+	Rules         map[string]int `json:"rules"`                   // executions by rule name
+	Subscriptions map[string]int `json:"subscriptions,omitempty"` // executions by subscription ID
+}
+
+// Summarize returns the summary of ael
+func Summarize(ael []ActionExecution) Summary {
+	summary := Summary{Rules: make(map[string]int), Subscriptions: make(map[string]int)}
+	resources := make(map[string]bool)
+	for _, ae := range ael {
+		resources[ae.ResourceID] = true
+		summary.add(ae)
+	}
+	summary.Resources = len(resources)
+	return summary
+}
+
+// AddResource adds the executions ael of a single resource to the summary, so runs can be summarized without
+// keeping their executions
+func (s *Summary) AddResource(ael []ActionExecution) {
+	if len(ael) == 0 {
+		return
+	}
+	if s.Rules == nil {
+		s.Rules = make(map[string]int)
+	}
+	if s.Subscriptions == nil {
+		s.Subscriptions = make(map[string]int)
+	}
+	s.Resources++
+	for _, ae := range ael {
+		s.add(ae)
+	}
+}
+
+// add counts the execution ae, but not its resource
+func (s *Summary) add(ae ActionExecution) {
+	s.Executions++
+	if ae.NonCompliant() {
+		s.NonCompliant++
+	}
+	s.Rules[ae.RuleName]++
+	if ae.Subscription != "" {
+		s.Subscriptions[ae.Subscription]++
+	}
+}
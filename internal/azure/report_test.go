@@ -0,0 +1,64 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupExecutions(t *testing.T) {
+	finops := rules.Rule{Name: "costs", Owner: "finops"}
+	platform := rules.Rule{Name: "env", Owner: "platform"}
+	unowned := rules.Rule{Name: "other"}
+
+	ael := []ActionExecution{
+		{ResourceID: "2", RuleName: finops.Name, Rule: finops},
+		{ResourceID: "1", RuleName: platform.Name, Rule: platform},
+		{ResourceID: "1", RuleName: finops.Name, Rule: finops},
+		{ResourceID: "3", RuleName: unowned.Name, Rule: unowned},
+	}
+
+	names, groups := GroupExecutions(ael, "owner")
+	assert.Equal(t, []string{NoGroup, "finops", "platform"}, names)
+	assert.Len(t, groups["finops"], 2)
+	assert.Equal(t, "1", groups["finops"][0].ResourceID)
+	assert.Len(t, groups["platform"], 1)
+	assert.Len(t, groups[NoGroup], 1)
+}
+
+func TestGroupExecutionsByTag(t *testing.T) {
+	ael := []ActionExecution{
+		{ResourceID: "2", RuleName: "costs", Tags: map[string]string{"owner": "ann@example.com"}},
+		{ResourceID: "1", RuleName: "env", Tags: map[string]string{"owner": "bob@example.com"}},
+		{ResourceID: "1", RuleName: "costs", Tags: map[string]string{"owner": "bob@example.com"}},
+		{ResourceID: "3", RuleName: "costs", Tags: map[string]string{"team": "platform"}},
+	}
+
+	names, groups := GroupExecutionsByTag(ael, "owner")
+	assert.Equal(t, []string{NoGroup, "ann@example.com", "bob@example.com"}, names)
+	assert.Len(t, groups["ann@example.com"], 1)
+	assert.Equal(t, []string{"costs", "env"}, []string{groups["bob@example.com"][0].RuleName, groups["bob@example.com"][1].RuleName})
+	assert.Equal(t, "3", groups[NoGroup][0].ResourceID)
+}
+
+func TestSummarize(t *testing.T) {
+	ael := []ActionExecution{
+		{ResourceID: "1", Subscription: "a", RuleName: "costs", Mode: rules.ModeAudit},
+		{ResourceID: "1", Subscription: "a", RuleName: "env", Mode: rules.ModeEnforce},
+		{ResourceID: "2", Subscription: "b", RuleName: "env", Mode: rules.ModeEnforce},
+	}
+	assert.Equal(t, Summary{
+		Resources:     2,
+		Executions:    3,
+		NonCompliant:  1,
+		Rules:         map[string]int{"costs": 1, "env": 2},
+		Subscriptions: map[string]int{"a": 2, "b": 1},
+	}, Summarize(ael))
+
+	var summary Summary
+	summary.AddResource(ael[:2])
+	summary.AddResource(ael[2:])
+	summary.AddResource(nil)
+	assert.Equal(t, Summarize(ael), summary)
+}
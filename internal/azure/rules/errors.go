@@ -0,0 +1,40 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors is a list of errors found while loading rules, reported together
+type Errors []error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "\t* " + err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// add appends err to the list, flattening nested Errors
+func (e *Errors) add(err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(Errors); ok {
+		*e = append(*e, errs...)
+		return
+	}
+	*e = append(*e, err)
+}
+
+// errorOrNil returns nil if there are no errors in the list
+func (e Errors) errorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,352 @@
+package rules
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// refType is the type of a condition referencing a named condition set
+const refType = "ref"
+
+// ruleFileExtensions are the extensions of files included from a directory
+var ruleFileExtensions = []string{".json", ".yaml", ".yml"}
+
+// varPattern matches a variable reference, or an escaped one starting with $$
+var varPattern = regexp.MustCompile(`\$?\$\{vars\.([^}]*)\}`)
+
+// loader reads rules definitions following their includes
+type loader struct {
+	stack  []string        // files currently being loaded, to detect include cycles
+	loaded map[string]bool // files already loaded, so that a file included twice is merged once
+	params map[string]string
+
+	origins map[string]string // URLs the files fetched from URLs were fetched from, by path of their local copy
+	origin  string            // URL the rules file was fetched from, if it was
+	fetch   IncludeFetcher    // fetches the files included by files fetched from URLs
+
+	publicKeys    []ed25519.PublicKey // keys verifying signatures of the files
+	digests       map[string]bool     // pinned sha256 of the files
+	requireSigned bool                // if true, unsigned files are an error
+}
+
+// source is where a rules definition being loaded comes from
+type source struct {
+	dir      string // directory includes are relative to
+	origin   string // URL the definition was fetched from, includes are relative to it if set
+	verified bool   // the signature or the digest of the definition was verified, so parameters can't change it
+}
+
+func newLoader(opts ...Option) *loader {
+	l := &loader{loaded: make(map[string]bool), origins: make(map[string]string)}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// loadFile reads filename and the files it includes
+func (l *loader) loadFile(filename string) (TagRules, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return TagRules{}, errors.Wrapf(err, "can't resolve path of %s", filename)
+	}
+
+	for i, loading := range l.stack {
+		if loading == path {
+			cycle := append(append([]string{}, l.stack[i:]...), path)
+			return TagRules{}, errors.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if l.loaded[path] {
+		return TagRules{}, nil
+	}
+	l.loaded[path] = true
+	if len(l.stack) == 0 && l.origin != "" {
+		l.origins[path] = l.origin
+	}
+
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return TagRules{}, errors.Wrap(err, "error opening the file")
+	}
+	verified, err := l.verify(path, dat)
+	if err != nil {
+		return TagRules{}, prefixErrors(err, filename)
+	}
+
+	l.stack = append(l.stack, path)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	t, err := l.load(string(dat), source{dir: filepath.Dir(path), origin: l.origins[path], verified: verified})
+	return t, prefixErrors(err, filename)
+}
+
+// load parses rulesDef, expands parameters in its values and merges it with the files it includes
+func (l *loader) load(rulesDef string, src source) (TagRules, error) {
+	rulesDef, err := l.expandDocument(rulesDef, src.verified)
+	if err != nil {
+		return TagRules{}, err
+	}
+	t, err := parseRulesDefinitions(rulesDef)
+	if err != nil {
+		return TagRules{}, err
+	}
+	for i := range t.Rules {
+		t.Rules[i].Dir = src.dir
+	}
+
+	var (
+		errs   Errors
+		merged TagRules
+	)
+	for _, include := range t.Include {
+		var files []string
+		if src.origin != "" {
+			files, err = l.fetchInclude(include, src.origin)
+		} else {
+			files, err = expandInclude(include, src.dir)
+		}
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		for _, file := range files {
+			included, err := l.loadFile(file)
+			if err != nil {
+				errs.add(err)
+				continue
+			}
+			errs.add(merged.merge(included, file))
+		}
+	}
+	errs.add(merged.merge(t, ""))
+
+	if err := errs.errorOrNil(); err != nil {
+		return TagRules{}, err
+	}
+	return merged, nil
+}
+
+// fetchInclude fetches include, relative to the URL origin of the including file, and returns its local copy. The
+// query of origin, which can hold a SAS token, is kept if include has none. include must have the scheme of origin,
+// so that a file fetched over HTTP can't make git or the file system be read
+func (l *loader) fetchInclude(include, origin string) ([]string, error) {
+	if l.fetch == nil {
+		return nil, errors.Errorf("include %q: files fetched from URLs can't include other files", include)
+	}
+	if strings.ContainsAny(include, "*?[") {
+		return nil, errors.Errorf("include %q: globs can't be included by files fetched from URLs", include)
+	}
+	base, err := url.Parse(origin)
+	if err != nil {
+		return nil, errors.Wrapf(err, "include %q", include)
+	}
+	ref, err := url.Parse(include)
+	if err != nil {
+		return nil, errors.Wrapf(err, "include %q", include)
+	}
+	u := base.ResolveReference(ref)
+	if !strings.EqualFold(u.Scheme, base.Scheme) {
+		return nil, errors.Errorf("include %q: a file fetched from a %s location can only include %s locations", include, base.Scheme, base.Scheme)
+	}
+	if ref.RawQuery == "" {
+		u.RawQuery = base.RawQuery
+	}
+
+	path, err := l.fetch(u.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "include %q", include)
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, errors.Wrapf(err, "include %q", include)
+	}
+	l.origins[path] = u.String()
+	return []string{path}, nil
+}
+
+// expandInclude returns the files matching include, which can be a file, a directory or a glob
+func expandInclude(include, dir string) ([]string, error) {
+	pattern := include
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		var files []string
+		for _, ext := range ruleFileExtensions {
+			matches, _ := filepath.Glob(filepath.Join(pattern, "*"+ext))
+			files = append(files, matches...)
+		}
+		if len(files) == 0 {
+			return nil, errors.Errorf("include %q: no rule files in the directory", include)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "include %q", include)
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("include %q: no such file", include)
+	}
+	return files, nil
+}
+
+// merge adds rules, variables, condition sets and scopes from other, loaded from file, to t. Variables of other take
+// precedence. The scopes of included files, with a file name, are kept apart, so that they can only narrow the scope
+func (t *TagRules) merge(other TagRules, file string) error {
+	var errs Errors
+
+	if other.Version > t.Version {
+		t.Version = other.Version
+	}
+	if other.DryRun != nil && (t.DryRun == nil || *other.DryRun) {
+		t.DryRun = other.DryRun
+	}
+
+	for name, value := range other.Vars {
+		if t.Vars == nil {
+			t.Vars = make(map[string]string)
+		}
+		t.Vars[name] = value
+	}
+
+	if other.Scope != nil {
+		if file == "" {
+			t.Scope = other.Scope
+		} else {
+			t.IncludedScopes = append(t.IncludedScopes, *other.Scope)
+		}
+	}
+	t.IncludedScopes = append(t.IncludedScopes, other.IncludedScopes...)
+
+	for id, conditions := range other.ConditionSets {
+		if _, ok := t.ConditionSets[id]; ok {
+			errs.add(errors.Errorf("condition set %q is defined more than once", id))
+			continue
+		}
+		if t.ConditionSets == nil {
+			t.ConditionSets = make(map[string][]ConditionItem)
+		}
+		t.ConditionSets[id] = conditions
+	}
+
+	t.Rules = append(t.Rules, other.Rules...)
+
+	return prefixErrors(errs.errorOrNil(), file)
+}
+
+// resolve expands references to condition sets and substitutes variables in the rules
+func (t TagRules) resolve() (TagRules, error) {
+	var errs Errors
+
+	resolved := t
+	resolved.Include, resolved.Vars, resolved.ConditionSets, resolved.Rules = nil, nil, nil, nil
+	for i, rule := range t.Rules {
+		prefix := fmt.Sprintf("rule %d (%q)", i, rule.Name)
+		conditions, err := t.expandConditions(rule.Conditions, nil)
+		if err != nil {
+			errs.add(errors.Wrap(err, prefix))
+			continue
+		}
+
+		var ruleErrs Errors
+		rule.Conditions = make([]ConditionItem, len(conditions))
+		for j, cond := range conditions {
+			rule.Conditions[j] = ConditionItem(t.substituteVars(cond, &ruleErrs))
+		}
+		actions := rule.Actions
+		rule.Actions = make([]ActionItem, len(actions))
+		for j, action := range actions {
+			rule.Actions[j] = ActionItem(t.substituteVars(action, &ruleErrs))
+		}
+		if len(ruleErrs) > 0 {
+			errs.add(prefixErrors(ruleErrs, prefix))
+			continue
+		}
+
+		resolved.Rules = append(resolved.Rules, rule)
+	}
+
+	if err := errs.errorOrNil(); err != nil {
+		return TagRules{}, err
+	}
+	return resolved, nil
+}
+
+// expandConditions replaces references in conditions with the conditions of the referenced set. seen holds the sets being expanded
+func (t TagRules) expandConditions(conditions []ConditionItem, seen []string) ([]ConditionItem, error) {
+	var expanded []ConditionItem
+	for _, cond := range conditions {
+		if cond.GetType() != refType {
+			expanded = append(expanded, cond)
+			continue
+		}
+
+		id := cond.GetString("id")
+		for _, s := range seen {
+			if s == id {
+				return nil, errors.Errorf("condition set cycle: %s -> %s", strings.Join(seen, " -> "), id)
+			}
+		}
+		set, ok := t.ConditionSets[id]
+		if !ok {
+			return nil, errors.Errorf("unknown condition set %q", id)
+		}
+		conds, err := t.expandConditions(set, append(seen, id))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, conds...)
+	}
+	return expanded, nil
+}
+
+// substituteVars returns a copy of item with ${vars.name} replaced by the variables of t, and $${vars.name} by a literal ${vars.name}
+func (t TagRules) substituteVars(item map[string]interface{}, errs *Errors) map[string]interface{} {
+	replace := func(value string) string {
+		return varPattern.ReplaceAllStringFunc(value, func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+			name := varPattern.FindStringSubmatch(ref)[1]
+			if v, ok := t.Vars[name]; ok {
+				return v
+			}
+			errs.add(errors.Errorf("undefined variable %q", name))
+			return ref
+		})
+	}
+
+	substituted := make(map[string]interface{}, len(item))
+	for key, value := range item {
+		substituted[key] = substituteStrings(value, replace)
+	}
+	return substituted
+}
+
+// prefixErrors prefixes err, or every error in a list, with prefix
+func prefixErrors(err error, prefix string) error {
+	if err == nil || prefix == "" {
+		return err
+	}
+	if errs, ok := err.(Errors); ok {
+		prefixed := make(Errors, len(errs))
+		for i, e := range errs {
+			prefixed[i] = errors.Wrap(e, prefix)
+		}
+		return prefixed
+	}
+	return errors.Wrap(err, prefix)
+}
@@ -0,0 +1,231 @@
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var includeWant = TagRules{Rules: []Rule{
+	{Name: "Tag cost center", Conditions: []ConditionItem{
+		{"type": "tagNotExists", "tag": "env"},
+	},
+		Actions: []ActionItem{
+			{"type": "addTag", "tag": "costcenter", "value": "1234"},
+		},
+	},
+	{Name: "Tag platform", Conditions: []ConditionItem{
+		{"type": "rgEqual", "resourceGroup": "platform"},
+	},
+		Actions: []ActionItem{
+			{"type": "addTag", "tag": "team", "value": "platform"},
+		},
+	},
+	{Name: "Tag production", Conditions: []ConditionItem{
+		{"type": "regionEqual", "region": "westeurope"},
+		{"type": "tagNotExists", "tag": "env"},
+	},
+		Actions: []ActionItem{
+			{"type": "addTag", "tag": "env", "value": "prod"},
+		},
+	},
+}}
+
+func TestNewFromFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     TagRules
+		wantDirs []string // directories of the rules, relative to testdata
+		wantErrs []string
+	}{
+		{name: "includes, condition sets and vars", filename: "testdata/include/main.yaml", want: includeWant, wantDirs: []string{"include/teams", "include/teams", "include"}},
+		{name: "include cycle", filename: "testdata/cycle/a.yaml", wantErrs: []string{"include cycle"}},
+		{name: "missing file", filename: "testdata/nothing.yaml", wantErrs: []string{"error opening the file"}},
+		{name: "all include errors reported", filename: "testdata/broken.yaml", wantErrs: []string{
+			`include "missing.yaml": no such file`,
+			`include "include/teams/*.yml": no such file`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFromFile(tt.filename)
+			for i, dir := range tt.wantDirs {
+				if want, _ := filepath.Abs(filepath.Join("testdata", dir)); got.Rules[i].Dir != want {
+					t.Errorf("rule %d defined in %s, want %s", i, got.Rules[i].Dir, want)
+				}
+				got.Rules[i].Dir = ""
+			}
+			if (err != nil) != (len(tt.wantErrs) > 0) {
+				t.Fatalf("NewFromFile() error = %v, wantErrs %v", err, tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("NewFromFile() error = %v, want it to contain %q", err, want)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewFromFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFromFile_Origin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// local copies of https://example.com/rules/main.yaml and of the file it includes
+	files := map[string]string{
+		"https://example.com/rules/main.yaml?sig=x":        "include: [common/team.yaml]\nrules:\n- name: main\n",
+		"https://example.com/rules/common/team.yaml?sig=x": "rules:\n- name: team\n",
+	}
+	paths := make(map[string]string)
+	for location, content := range files {
+		path := filepath.Join(dir, Digest([]byte(location)))
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths[location] = path
+	}
+
+	var fetched []string
+	fetch := func(location string) (string, error) {
+		fetched = append(fetched, location)
+		return paths[location], nil
+	}
+	got, err := NewFromFile(paths["https://example.com/rules/main.yaml?sig=x"], WithOrigin("https://example.com/rules/main.yaml?sig=x", fetch))
+	if err != nil {
+		t.Fatalf("NewFromFile() error = %v", err)
+	}
+	if len(got.Rules) != 2 || got.Rules[0].Name != "team" || got.Rules[1].Name != "main" {
+		t.Errorf("NewFromFile() = %v, want the rules of both files", got)
+	}
+	if !reflect.DeepEqual(fetched, []string{"https://example.com/rules/common/team.yaml?sig=x"}) {
+		t.Errorf("fetched %v, want the include resolved against the URL", fetched)
+	}
+
+	if _, err := NewFromFile(paths["https://example.com/rules/main.yaml?sig=x"], WithOrigin("https://example.com/rules/main.yaml", nil)); err == nil {
+		t.Error("NewFromFile() expected an error without a fetcher")
+	}
+
+	// a file fetched over HTTPS can't include git or local locations, which could run git with options
+	hostile := filepath.Join(dir, "hostile.yaml")
+	for _, include := range []string{"git+file:///x//rules.yaml@--upload-pack=touch pwned", "file:///etc/rules.yaml"} {
+		if err := ioutil.WriteFile(hostile, []byte("include: ['"+include+"']\nrules:\n- name: main\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fetched = nil
+		if _, err := NewFromFile(hostile, WithOrigin("https://example.com/rules/main.yaml", fetch)); err == nil {
+			t.Errorf("NewFromFile() expected an error including %s", include)
+		}
+		if len(fetched) != 0 {
+			t.Errorf("fetched %v, want nothing fetched", fetched)
+		}
+	}
+}
+
+func TestNewFromString_Resolve(t *testing.T) {
+	def := `{
+		"conditionSets": {"loop": [{"type": "ref", "id": "loop"}]},
+		"rules": [
+			{"name": "cycle", "conditions": [{"type": "ref", "id": "loop"}]},
+			{"name": "unknown", "conditions": [{"type": "ref", "id": "nowhere"}]},
+			{"name": "variable", "actions": [{"type": "addTag", "tag": "env", "value": "${vars.env}"}]}
+		]
+	}`
+
+	_, err := NewFromString(def)
+	if err == nil {
+		t.Fatal("NewFromString() expected an error")
+	}
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("NewFromString() error = %v, want 3 errors", err)
+	}
+	for i, want := range []string{"condition set cycle: loop -> loop", `unknown condition set "nowhere"`, `undefined variable "env"`} {
+		if !strings.Contains(errs[i].Error(), want) {
+			t.Errorf("error %d = %v, want it to contain %q", i, errs[i], want)
+		}
+	}
+}
+
+func TestNewFromString_Params(t *testing.T) {
+	os.Setenv("TAGMANAGER_TEST_ENV", "prod")
+	defer os.Unsetenv("TAGMANAGER_TEST_ENV")
+
+	tests := []struct {
+		name     string
+		rulesDef string
+		params   map[string]string
+		want     string
+		wantErrs []string
+	}{
+		{name: "environment", rulesDef: "${TAGMANAGER_TEST_ENV}", want: "prod"},
+		{name: "parameter before environment", rulesDef: "${TAGMANAGER_TEST_ENV}", params: map[string]string{"TAGMANAGER_TEST_ENV": "dev"}, want: "dev"},
+		{name: "default", rulesDef: "${region:-westeurope}", want: "westeurope"},
+		{name: "default not used", rulesDef: "${region:-westeurope}", params: map[string]string{"region": "northeurope"}, want: "northeurope"},
+		{name: "escaped", rulesDef: "$${TAGMANAGER_TEST_ENV} costs $5", want: "${TAGMANAGER_TEST_ENV} costs $5"},
+		{name: "rule variables are kept", rulesDef: "${vars.env}", want: "${vars.env}"},
+		{name: "escaped rule variables are kept", rulesDef: "$${vars.env} $${a}", want: "$${vars.env} ${a}"},
+		{name: "not set", rulesDef: "${a} ${b:?needed for tagging}", wantErrs: []string{"parameter a is not set", "parameter b: needed for tagging"}},
+		{name: "unterminated", rulesDef: "${a", wantErrs: []string{"unterminated parameter reference"}},
+		{name: "invalid", rulesDef: "${a:+b}", wantErrs: []string{"invalid parameter reference ${a:+b}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newLoader(WithParams(tt.params)).expandParams(tt.rulesDef, false)
+			if (err != nil) != (len(tt.wantErrs) > 0) {
+				t.Fatalf("expandParams() error = %v, wantErrs %v", err, tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expandParams() error = %v, want it to contain %q", err, want)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("expandParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got, err := NewFromString(`{"rules": [{"name": "${name}", "actions": [{"type": "addTag", "tag": "env", "value": "${TAGMANAGER_TEST_ENV}"}]}]}`, WithParams(map[string]string{"name": "env"}))
+	if err != nil {
+		t.Fatalf("NewFromString() error = %v", err)
+	}
+	want := TagRules{Rules: []Rule{{Name: "env", Conditions: []ConditionItem{}, Actions: []ActionItem{{"type": "addTag", "tag": "env", "value": "prod"}}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewFromString() = %v, want %v", got, want)
+	}
+	// references in comments are ignored, and values can't add structure to the rules
+	injected := "x\n  - type: exec\n    command: [rm, -rf, /]"
+	got, err = NewFromString("# set ${UNSET_IN_COMMENT} to change the tag\nrules:\n- name: env\n  actions:\n  - type: addTag\n    tag: env\n    value: ${value}\n",
+		WithParams(map[string]string{"value": injected}))
+	if err != nil {
+		t.Fatalf("NewFromString() error = %v", err)
+	}
+	want = TagRules{Rules: []Rule{{Name: "env", Conditions: []ConditionItem{}, Actions: []ActionItem{{"type": "addTag", "tag": "env", "value": injected}}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewFromString() = %v, want %v", got, want)
+	}
+	got, err = NewFromString(`{"rules": [{"name": "${name}"}]}`, WithParams(map[string]string{"name": `a"}, {"name": "b`}))
+	if err != nil || len(got.Rules) != 1 || got.Rules[0].Name != `a"}, {"name": "b` {
+		t.Errorf("NewFromString() = %v, %v, want one rule named after the parameter", got, err)
+	}
+	// $${vars.name} is escaped once, for both parameters and variables
+	got, err = NewFromString(`{"vars": {"env": "prod"}, "rules": [{"name": "env", "actions": [{"type": "addTag", "tag": "env", "value": "$${vars.env} is ${vars.env}"}]}]}`)
+	if err != nil {
+		t.Fatalf("NewFromString() error = %v", err)
+	}
+	if value := got.Rules[0].Actions[0]["value"]; value != "${vars.env} is prod" {
+		t.Errorf("NewFromString() value = %v, want %v", value, "${vars.env} is prod")
+	}
+}
@@ -0,0 +1,171 @@
+package rules
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Option changes how rules definitions are loaded
+type Option func(*loader)
+
+// WithParams sets parameters substituted for ${name} in rules definitions. Parameters take precedence over environment variables
+func WithParams(params map[string]string) Option {
+	return func(l *loader) {
+		l.params = params
+	}
+}
+
+// IncludeFetcher fetches the file at the URL location and returns the path of its local copy
+type IncludeFetcher func(location string) (string, error)
+
+// WithOrigin sets the URL the rules file was fetched from. The files it includes are resolved against the URL and
+// fetched with fetch, instead of being read next to the local copy of the file
+func WithOrigin(origin string, fetch IncludeFetcher) Option {
+	return func(l *loader) {
+		l.origin, l.fetch = origin, fetch
+	}
+}
+
+// lookupParam returns the value of the parameter name, either from the parameters or from the environment
+func (l *loader) lookupParam(name string) (string, bool) {
+	if value, ok := l.params[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// expandDocument parses rulesDef and substitutes parameters in its string values, and returns it as JSON. Parameters
+// are substituted after parsing, so that their values can't change the structure of the rules, and references in
+// comments are ignored. Parameters are an error in a verified definition, as signatures and digests cover the
+// definition before parameters are substituted
+func (l *loader) expandDocument(rulesDef string, verified bool) (string, error) {
+	var doc interface{}
+	byt := []byte(rulesDef)
+	if hasJSONPrefix(byt) {
+		if err := json.Unmarshal(byt, &doc); err != nil {
+			return "", errors.Wrap(err, "can't unmarshal json rules")
+		}
+	} else if err := yaml.Unmarshal(byt, &doc); err != nil {
+		return "", errors.Wrap(err, "can't unmarshal yaml rules")
+	}
+
+	var errs Errors
+	doc = substituteStrings(doc, func(value string) string {
+		expanded, err := l.expandParams(value, verified)
+		if err != nil {
+			errs.add(err)
+			return value
+		}
+		return expanded
+	})
+	if err := errs.errorOrNil(); err != nil {
+		return "", err
+	}
+
+	expanded, err := json.Marshal(doc)
+	if err != nil {
+		return "", errors.Wrap(err, "can't marshal expanded rules")
+	}
+	return string(expanded), nil
+}
+
+// expandParams substitutes parameters in the string value s. The supported forms are:
+//
+//	${NAME}          value of NAME, an error if it is not set
+//	${NAME:-default} value of NAME, default if it is not set or empty
+//	${NAME:?message} value of NAME, an error with message if it is not set or empty
+//	$${              a literal ${
+//
+// ${vars.name} references, escaped or not, are left for the substitution of variables defined in the rules, which
+// unescapes them. If verified is true, parameter references are an error.
+func (l *loader) expandParams(s string, verified bool) (string, error) {
+	var (
+		errs Errors
+		out  strings.Builder
+	)
+
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${vars.") {
+			out.WriteByte('$')
+			i++
+		} else if strings.HasPrefix(s[i:], "$${") {
+			out.WriteString("${")
+			i += len("$${")
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			errs.add(errors.Errorf("unterminated parameter reference at offset %d", i))
+			break
+		}
+		ref := s[i : i+end+1]
+		i += end + 1
+
+		expr := ref[len("${") : len(ref)-1]
+		if strings.HasPrefix(expr, "vars.") {
+			out.WriteString(ref)
+			continue
+		}
+
+		if verified {
+			errs.add(errors.Errorf("parameter ${%s} can't be used in a signed or pinned file, the signature covers the file before parameters are substituted", expr))
+			continue
+		}
+		value, err := l.expandParam(expr)
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		out.WriteString(value)
+	}
+
+	if err := errs.errorOrNil(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// expandParam returns the value of a single parameter expression, without ${ and }
+func (l *loader) expandParam(expr string) (string, error) {
+	name, op, arg := expr, "", ""
+	if i := strings.Index(expr, ":"); i >= 0 {
+		name, op = expr[:i], expr[i:]
+		if len(op) < 2 || (op[1] != '-' && op[1] != '?') {
+			return "", errors.Errorf("invalid parameter reference ${%s}", expr)
+		}
+		op, arg = op[:2], op[2:]
+	}
+	if name == "" {
+		return "", errors.Errorf("invalid parameter reference ${%s}", expr)
+	}
+
+	value, ok := l.lookupParam(name)
+	switch op {
+	case ":-":
+		if !ok || value == "" {
+			return arg, nil
+		}
+	case ":?":
+		if !ok || value == "" {
+			if arg == "" {
+				arg = "required parameter is not set"
+			}
+			return "", errors.Errorf("parameter %s: %s", name, arg)
+		}
+	default:
+		if !ok {
+			return "", errors.Errorf("parameter %s is not set", name)
+		}
+	}
+	return value, nil
+}
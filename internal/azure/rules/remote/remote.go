@@ -0,0 +1,369 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+const (
+	gitPrefix      = "git+"
+	blobHostSuffix = ".blob.core.windows.net"
+	blobAPIVersion = "2019-12-12"
+	metaFileName   = "meta.json"
+)
+
+// Source describes where rules were loaded from
+type Source struct {
+	Location string `json:"location"`           // location as given by the user, without the query of URLs
+	Path     string `json:"-"`                  // local file with the rules
+	Revision string `json:"revision,omitempty"` // git commit, blob version or ETag of the fetched rules
+	Digest   string `json:"digest"`             // sha256 of the rules
+}
+
+// String returns the source in a form used in reports
+func (s Source) String() string {
+	if s.Revision == "" {
+		return fmt.Sprintf("%s (sha256 %s)", s.Location, s.Digest)
+	}
+	return fmt.Sprintf("%s@%s (sha256 %s)", s.Location, s.Revision, s.Digest)
+}
+
+// Fetcher fetches rules from local files, HTTP(S) URLs, git repositories and Azure blobs into a local cache
+type Fetcher struct {
+	CacheDir   string
+	Client     *http.Client
+	Authorizer autorest.Authorizer // authorizes blob requests without a SAS token, optional
+	Offline    bool                // use the cached copy of rules that can't be fetched, instead of failing
+	Signatures bool                // fetch the detached signatures of rules fetched over HTTP, to verify them
+}
+
+// NewFetcher creates a Fetcher caching in cacheDir, or in the user cache directory if cacheDir is empty
+func NewFetcher(cacheDir string) *Fetcher {
+	if cacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "tagmanager", "rules")
+		} else {
+			cacheDir = filepath.Join(os.TempDir(), "tagmanager", "rules")
+		}
+	}
+	return &Fetcher{
+		CacheDir: cacheDir,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// IsHTTP returns true if location is a HTTP(S) URL, including Azure blob URLs
+func IsHTTP(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// IsBlob returns true if location is an Azure blob URL
+func IsBlob(location string) bool {
+	u, err := url.Parse(location)
+	return err == nil && u.Scheme == "https" && strings.HasSuffix(u.Hostname(), blobHostSuffix)
+}
+
+// Fetch makes the rules from location available locally. Locations are:
+//
+//	path/to/rules.yaml                                    a local file
+//	https://example.com/rules.yaml                        a HTTP(S) URL
+//	https://account.blob.core.windows.net/container/blob  an Azure blob, with a SAS token or authorized with Authorizer
+//	git+https://example.com/repo.git//path/rules.yaml@ref a file in a git repository, at a branch, tag or commit
+func (f *Fetcher) Fetch(location string) (*Source, error) {
+	switch {
+	case strings.HasPrefix(location, gitPrefix):
+		return f.fetchGit(location)
+	case IsHTTP(location):
+		return f.fetchHTTP(location)
+	}
+
+	digest, err := fileDigest(location)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{Location: location, Path: location, Digest: digest}, nil
+}
+
+// httpMeta is stored next to content fetched over HTTP
+type httpMeta struct {
+	URL       string    `json:"url"`
+	ETag      string    `json:"etag,omitempty"`
+	Revision  string    `json:"revision,omitempty"`
+	Digest    string    `json:"digest"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// fetchHTTP downloads location, revalidating the cached copy with its ETag. Every fetched version is kept
+// in the cache under its digest. If the server can't be reached, the last fetched version is used if f is Offline
+func (f *Fetcher) fetchHTTP(location string) (*Source, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid rules URL %s", location)
+	}
+	dir := filepath.Join(f.CacheDir, "http", cacheKey(location))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "can't create rules cache")
+	}
+
+	var cached *httpMeta
+	if dat, err := ioutil.ReadFile(filepath.Join(dir, metaFileName)); err == nil {
+		cached = &httpMeta{}
+		if err := json.Unmarshal(dat, cached); err != nil {
+			cached = nil
+		}
+	}
+
+	etag := ""
+	if cached != nil {
+		etag = cached.ETag
+	}
+	resp, err := f.get(u, etag)
+	if err != nil {
+		if cached != nil && f.Offline {
+			log.Warnf("Can't fetch rules from %s, using the cached version fetched at %s: %s", redact(u), cached.FetchedAt, err)
+			return cached.source(dir), nil
+		}
+		if cached != nil {
+			return nil, errors.Wrapf(err, "can't fetch rules from %s, a cached version can be used offline", redact(u))
+		}
+		return nil, errors.Wrapf(err, "can't fetch rules from %s", redact(u))
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		if err := f.fetchSignature(u, filepath.Join(dir, cached.Digest)+rules.SignatureExt); err != nil {
+			return nil, err
+		}
+		return cached.source(dir), nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("can't fetch rules from %s: %s", redact(u), resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read rules from %s", redact(u))
+	}
+	meta := &httpMeta{
+		URL:       redact(u),
+		ETag:      resp.Header.Get("ETag"),
+		Revision:  strings.Trim(resp.Header.Get("ETag"), `"`),
+		Digest:    rules.Digest(content),
+		FetchedAt: time.Now().UTC(),
+	}
+	if IsBlob(location) && resp.Header.Get("x-ms-version-id") != "" {
+		meta.Revision = resp.Header.Get("x-ms-version-id")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, meta.Digest), content, 0600); err != nil {
+		return nil, errors.Wrap(err, "can't cache rules")
+	}
+	if err := f.fetchSignature(u, filepath.Join(dir, meta.Digest)+rules.SignatureExt); err != nil {
+		return nil, err
+	}
+	dat, _ := json.Marshal(meta)
+	if err := ioutil.WriteFile(filepath.Join(dir, metaFileName), dat, 0600); err != nil {
+		return nil, errors.Wrap(err, "can't cache rules")
+	}
+
+	return meta.source(dir), nil
+}
+
+// get requests u, with the headers and authorization of blobs if u is a blob
+func (f *Fetcher) get(u *url.URL, etag string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid rules URL %s", redact(u))
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if IsBlob(u.String()) {
+		req.Header.Set("x-ms-version", blobAPIVersion)
+		if u.Query().Get("sig") == "" && f.Authorizer != nil {
+			req, err = autorest.Prepare(req, f.Authorizer.WithAuthorization())
+			if err != nil {
+				return nil, errors.Wrap(err, "can't authorize blob request")
+			}
+		}
+	}
+	resp, err := f.Client.Do(req)
+	if uerr, ok := err.(*url.Error); ok {
+		uerr.URL = redact(u)
+	}
+	return resp, err
+}
+
+// fetchSignature downloads the detached signature of the rules at u to filename if f fetches Signatures. Rules
+// without a readable signature, as a SAS token or an endpoint can forbid it, are left unsigned for the verifier
+// to accept or refuse
+func (f *Fetcher) fetchSignature(u *url.URL, filename string) error {
+	if !f.Signatures {
+		return nil
+	}
+	sigURL := *u
+	sigURL.Path += rules.SignatureExt
+	resp, err := f.get(&sigURL, "")
+	if err != nil {
+		return errors.Wrapf(err, "can't fetch signature from %s", redact(&sigURL))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Infof("No signature of the rules at %s: %s", redact(u), resp.Status)
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "can't remove cached signature")
+		}
+		return nil
+	}
+	signature, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "can't read signature from %s", redact(&sigURL))
+	}
+	return errors.Wrap(ioutil.WriteFile(filename, signature, 0600), "can't cache signature")
+}
+
+// source returns the Source of the content cached in dir. The location is redacted as it can hold a SAS token
+func (m httpMeta) source(dir string) *Source {
+	return &Source{
+		Location: m.URL,
+		Path:     filepath.Join(dir, m.Digest),
+		Revision: m.Revision,
+		Digest:   m.Digest,
+	}
+}
+
+// fetchGit checks out the repository of location in the cache and returns the file from it.
+// The location has the form git+<repository URL>//<path in the repository>[@<ref>]. Credentials in the
+// repository URL are removed from the location of the source and from errors
+func (f *Fetcher) fetchGit(location string) (*Source, error) {
+	repo, path, ref, err := parseGitLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	src, err := f.checkoutGit(repo, path, ref)
+	redacted := redactRepo(repo)
+	if err != nil {
+		return nil, errors.New(strings.Replace(err.Error(), repo, redacted, -1))
+	}
+	src.Location = strings.Replace(location, repo, redacted, 1)
+	return src, nil
+}
+
+// checkoutGit checks out ref of the repository repo in the cache and returns the file path from it
+func (f *Fetcher) checkoutGit(repo, path, ref string) (*Source, error) {
+	dir := filepath.Join(f.CacheDir, "git", cacheKey(repo))
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, errors.Wrap(err, "can't create rules cache")
+		}
+		if _, err := git(dir, "init", "-q"); err != nil {
+			return nil, err
+		}
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := git(dir, "fetch", "-q", "--depth", "1", "--", repo, ref); err != nil {
+		return nil, errors.Wrapf(err, "can't fetch %s from %s", ref, repo)
+	}
+	if _, err := git(dir, "checkout", "-q", "--force", "FETCH_HEAD"); err != nil {
+		return nil, errors.Wrapf(err, "can't check out %s from %s", ref, repo)
+	}
+	commit, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	digest, err := fileDigest(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s at %s", path, commit)
+	}
+	return &Source{Path: file, Revision: commit, Digest: digest}, nil
+}
+
+// parseGitLocation splits git+<repo>//<path>[@<ref>] into its parts
+func parseGitLocation(location string) (repo, path, ref string, err error) {
+	rest := strings.TrimPrefix(location, gitPrefix)
+	schemeEnd := strings.Index(rest, "://")
+	start := 0
+	if schemeEnd >= 0 {
+		start = schemeEnd + len("://")
+	}
+	sep := strings.Index(rest[start:], "//")
+	if sep < 0 {
+		return "", "", "", errors.Errorf("invalid git location %s, expected git+<repository>//<path>[@<ref>]", location)
+	}
+	repo, path = rest[:start+sep], rest[start+sep+len("//"):]
+	if at := strings.LastIndex(path, "@"); at >= 0 {
+		path, ref = path[:at], path[at+1:]
+	}
+	if repo == "" || path == "" {
+		return "", "", "", errors.Errorf("invalid git location %s, expected git+<repository>//<path>[@<ref>]", location)
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == ".." {
+			return "", "", "", errors.Errorf("invalid git location %s, the path can't leave the repository", location)
+		}
+	}
+	// git would take them for options
+	if strings.HasPrefix(repo, "-") || strings.HasPrefix(ref, "-") {
+		return "", "", "", errors.Errorf("invalid git location %s, the repository and the ref can't start with -", location)
+	}
+	return repo, path, ref, nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func fileDigest(filename string) (string, error) {
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errors.Wrap(err, "error opening the file")
+	}
+	return rules.Digest(dat), nil
+}
+
+func cacheKey(location string) string {
+	return rules.Digest([]byte(location))[:16]
+}
+
+// redactRepo removes credentials from the URL of a git repository. Other forms, like user@host:path, are returned as is
+func redactRepo(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.Scheme == "" {
+		return repo
+	}
+	return redact(u)
+}
+
+// redact removes the query, which can hold a SAS token, and credentials from u
+func redact(u *url.URL) string {
+	redacted := *u
+	redacted.RawQuery = ""
+	redacted.User = nil
+	return redacted.String()
+}
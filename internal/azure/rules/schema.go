@@ -0,0 +1,186 @@
+package rules
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// refSpec describes references to condition sets, which are resolved when the rules are loaded
+var refSpec = Spec{
+	Type:        "ref",
+	Description: "References the conditions of a condition set",
+	Params:      []Param{{Name: "id", Type: StringParam, Required: true, Description: "Name of the condition set"}},
+}
+
+// strictSuffix is the suffix of the definitions of version 2, where conditions and actions can't have unknown
+// parameters
+const strictSuffix = ".v2"
+
+// JSONSchema returns a JSON Schema (draft-07) of rules definitions with the given types of conditions and actions.
+// Like the loader, the schema accepts unknown properties, except for parameters of conditions and actions from
+// version 2
+func JSONSchema(conditions, actions []Spec) ([]byte, error) {
+	conditions = append(append([]Spec{}, conditions...), refSpec)
+	definitions := make(map[string]interface{})
+	for _, suffix := range []string{"", strictSuffix} {
+		strict := suffix == strictSuffix
+		definitions["rule"+suffix] = ruleSchema(suffix)
+		definitions["condition"+suffix] = itemsSchema("condition", suffix, conditions)
+		definitions["action"+suffix] = itemsSchema("action", suffix, actions)
+		for _, spec := range conditions {
+			definitions["condition"+suffix+"."+spec.Type] = spec.schema(strict)
+		}
+		for _, spec := range actions {
+			definitions["action"+suffix+"."+spec.Type] = spec.schema(strict)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "Azure tag manager rules",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"version":       map[string]interface{}{"type": "integer", "minimum": Version1, "maximum": LatestVersion, "description": "Version of the rules format, 1 if not set"},
+			"dryrun":        map[string]interface{}{"type": "boolean", "description": "If true, no actions are executed"},
+			"include":       stringList("Files, directories or globs with rules to include"),
+			"vars":          stringMap("Variables substituted for ${vars.name} in conditions and actions"),
+			"conditionSets": conditionSetsSchema(""),
+			"scope":         scopeSchema(),
+			"rules":         arrayOf("#/definitions/rule"),
+		},
+		"if": map[string]interface{}{
+			"properties": map[string]interface{}{"version": map[string]interface{}{"minimum": Version2}},
+			"required":   []string{"version"},
+		},
+		"then": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"conditionSets": conditionSetsSchema(strictSuffix),
+				"rules":         arrayOf("#/definitions/rule" + strictSuffix),
+			},
+		},
+		"definitions": definitions,
+	}
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't marshal the schema")
+	}
+	return out, nil
+}
+
+func conditionSetsSchema(suffix string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          "Named conditions referenced by {\"type\": \"ref\", \"id\": name}",
+		"additionalProperties": arrayOf("#/definitions/condition" + suffix),
+	}
+}
+
+func ruleSchema(suffix string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":        map[string]interface{}{"type": "string"},
+			"mode":        map[string]interface{}{"enum": []string{ModeEnforce, ModeAudit, ModeDisabled}, "description": "enforce if not set"},
+			"description": map[string]interface{}{"type": "string"},
+			"owner":       map[string]interface{}{"type": "string", "description": "Team or person responsible for the rule"},
+			"ticket":      map[string]interface{}{"type": "string", "description": "Ticket or change request behind the rule"},
+			"severity":    map[string]interface{}{"enum": severities},
+			"labels":      stringMap(""),
+			"conditions":  arrayOf("#/definitions/condition" + suffix),
+			"actions":     arrayOf("#/definitions/action" + suffix),
+		},
+	}
+}
+
+// itemsSchema returns the schema of conditions or actions, which is the schema of the type given in the type property
+func itemsSchema(kind, suffix string, specs []Spec) map[string]interface{} {
+	types := make([]string, len(specs))
+	var branches []interface{}
+	for i, spec := range specs {
+		types[i] = spec.Type
+		branches = append(branches, map[string]interface{}{
+			"if":   map[string]interface{}{"properties": map[string]interface{}{"type": map[string]interface{}{"const": spec.Type}}},
+			"then": map[string]interface{}{"$ref": "#/definitions/" + kind + suffix + "." + spec.Type},
+		})
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"required":   []string{"type"},
+		"properties": map[string]interface{}{"type": map[string]interface{}{"enum": types}},
+		"allOf":      branches,
+	}
+}
+
+// schema returns the schema of the items of the spec. If strict is true, unknown parameters are not allowed
+func (s Spec) schema(strict bool) map[string]interface{} {
+	properties := map[string]interface{}{
+		"type": map[string]interface{}{"const": s.Type},
+	}
+	required := []string{"type"}
+	for _, param := range s.Params {
+		properties[param.Name] = param.schema()
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"description":          s.Description,
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": !strict,
+	}
+}
+
+func (p Param) schema() map[string]interface{} {
+	scalar := []string{"string", "number", "boolean"}
+	var schema map[string]interface{}
+	switch p.Type {
+	case StringListParam:
+		schema = map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"type": scalar},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": scalar}},
+		}}
+	case StringMapParam:
+		schema = map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": scalar}}
+	default:
+		schema = map[string]interface{}{"type": scalar}
+	}
+	if p.Description != "" {
+		schema["description"] = p.Description
+	}
+	return schema
+}
+
+func scopeSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "object",
+		"description": "Resources scanned for the rules, patterns are case insensitive globs",
+		"properties": map[string]interface{}{
+			"resourceGroups":        stringList("Resource groups to scan, all if empty"),
+			"excludeResourceGroups": stringList("Resource groups never scanned"),
+			"types":                 stringList("Types of resources to scan, like Microsoft.Compute/*, all if empty"),
+			"excludeTypes":          stringList("Types of resources never scanned"),
+			"regions":               stringList("Regions of resources to scan, all if empty"),
+			"excludeRegions":        stringList("Regions of resources never scanned"),
+		},
+	}
+}
+
+func arrayOf(ref string) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": ref}}
+}
+
+func stringList(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": description}
+}
+
+func stringMap(description string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}}
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
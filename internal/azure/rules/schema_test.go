@@ -0,0 +1,52 @@
+package rules
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	conditions := []Spec{{Type: "tagValueIn", Params: []Param{
+		{Name: "tag", Type: StringParam, Required: true},
+		{Name: "values", Type: StringListParam, Required: true},
+	}}}
+	actions := []Spec{{Type: "cleanTags"}}
+
+	out, err := JSONSchema(conditions, actions)
+	if err != nil {
+		t.Fatalf("JSONSchema() error = %v", err)
+	}
+	var schema struct {
+		Definitions map[string]struct {
+			Required             []string                          `json:"required"`
+			Properties           map[string]map[string]interface{} `json:"properties"`
+			AdditionalProperties interface{}                       `json:"additionalProperties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(out, &schema); err != nil {
+		t.Fatalf("JSONSchema() returned invalid JSON: %v", err)
+	}
+
+	for _, name := range []string{"rule", "condition", "action", "condition.tagValueIn", "condition.ref", "action.cleanTags", "rule.v2", "condition.v2.tagValueIn"} {
+		if _, ok := schema.Definitions[name]; !ok {
+			t.Errorf("JSONSchema() has no definition %q", name)
+		}
+	}
+	if got, want := schema.Definitions["condition.tagValueIn"].Required, []string{"type", "tag", "values"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("required parameters = %v, want %v", got, want)
+	}
+	if got, want := schema.Definitions["condition"].Properties["type"]["enum"], []interface{}{"tagValueIn", "ref"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("condition types = %v, want %v", got, want)
+	}
+	// unknown parameters are accepted by the loader in version 1, and refused from version 2
+	if got := schema.Definitions["condition.tagValueIn"].AdditionalProperties; got != true {
+		t.Errorf("additional parameters in version 1 = %v, want true", got)
+	}
+	if got := schema.Definitions["condition.v2.tagValueIn"].AdditionalProperties; got != false {
+		t.Errorf("additional parameters in version 2 = %v, want false", got)
+	}
+	if len(conditions) != 1 {
+		t.Errorf("JSONSchema() modified the conditions")
+	}
+}
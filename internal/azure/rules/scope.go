@@ -0,0 +1,120 @@
+package rules
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Scope limits the resources scanned. Patterns are case insensitive globs where * matches any characters and ? one
+// character. Empty include lists include everything
+type Scope struct {
+	ResourceGroups        []string `json:"resourceGroups,omitempty"`        // resource groups to scan
+	ExcludeResourceGroups []string `json:"excludeResourceGroups,omitempty"` // resource groups never scanned
+	Types                 []string `json:"types,omitempty"`                 // types of resources to scan, like Microsoft.Compute/*
+	ExcludeTypes          []string `json:"excludeTypes,omitempty"`
+	Regions               []string `json:"regions,omitempty"`
+	ExcludeRegions        []string `json:"excludeRegions,omitempty"`
+}
+
+// IsZero returns true if the scope includes everything
+func (s Scope) IsZero() bool {
+	return len(s.ResourceGroups) == 0 && len(s.ExcludeResourceGroups) == 0 && len(s.Types) == 0 &&
+		len(s.ExcludeTypes) == 0 && len(s.Regions) == 0 && len(s.ExcludeRegions) == 0
+}
+
+// IncludesResourceGroup returns true if the resource group named rg is in the scope
+func (s Scope) IncludesResourceGroup(rg string) bool {
+	return included(s.ResourceGroups, s.ExcludeResourceGroups, rg)
+}
+
+// IncludesResource returns true if resources of the type in the region are in the scope
+func (s Scope) IncludesResource(typ, region string) bool {
+	return included(s.Types, s.ExcludeTypes, typ) && included(s.Regions, s.ExcludeRegions, region)
+}
+
+// Scopes is a list of scopes a resource must all be in, like the scope of the rules and the scope given on the command line
+type Scopes []Scope
+
+// AllScopes returns the scope of the rules and the scopes of the included files, which a resource must all be in
+func (t TagRules) AllScopes() Scopes {
+	var scopes Scopes
+	if t.Scope != nil && !t.Scope.IsZero() {
+		scopes = append(scopes, *t.Scope)
+	}
+	for _, scope := range t.IncludedScopes {
+		if !scope.IsZero() {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// IncludesResourceGroup returns true if the resource group named rg is in all the scopes
+func (s Scopes) IncludesResourceGroup(rg string) bool {
+	for _, scope := range s {
+		if !scope.IncludesResourceGroup(rg) {
+			return false
+		}
+	}
+	return true
+}
+
+// IncludesResource returns true if resources of the type in the region are in all the scopes
+func (s Scopes) IncludesResource(typ, region string) bool {
+	for _, scope := range s {
+		if !scope.IncludesResource(typ, region) {
+			return false
+		}
+	}
+	return true
+}
+
+func included(include, exclude []string, s string) bool {
+	for _, pattern := range exclude {
+		if Glob(pattern, s) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if Glob(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Glob returns true if s matches pattern, ignoring case. * matches any characters, including /, and ? one character
+func Glob(pattern, s string) bool {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	// position of the last * in the pattern and of the character of s it matches up to, for backtracking
+	star, matched := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, matched = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			if pattern[p] == '?' {
+				_, size := utf8.DecodeRuneInString(s[i:])
+				i += size
+			} else {
+				i++
+			}
+			p++
+		case star >= 0:
+			_, size := utf8.DecodeRuneInString(s[matched:])
+			matched += size
+			p, i = star+1, matched
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,86 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"prod-*", "PROD-web", true},
+		{"prod-*", "dev-web", false},
+		{"*-shared-*", "weu-shared-network", true},
+		{"Microsoft.Sql/*", "Microsoft.Sql/servers/databases", true},
+		{"rg-?", "rg-1", true},
+		{"rg-?", "rg-10", false},
+		{"rg-?", "rg-é", true},
+		{"*", "", true},
+		{"", "a", false},
+		{"a*b*c", "abxbc", true},
+		{"a*b*c", "abxbd", false},
+	}
+	for _, tt := range tests {
+		if got := Glob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("Glob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	file := Scope{ResourceGroups: []string{"prod-*", "shared"}, ExcludeResourceGroups: []string{"*-locked"}, ExcludeTypes: []string{"Microsoft.Network/*"}}
+	flags := Scope{ExcludeResourceGroups: []string{"prod-legacy"}, Regions: []string{"westeurope"}}
+	scopes := Scopes{file, flags}
+
+	for rg, want := range map[string]bool{"prod-web": true, "Shared": true, "dev-web": false, "prod-locked": false, "prod-legacy": false} {
+		if got := scopes.IncludesResourceGroup(rg); got != want {
+			t.Errorf("IncludesResourceGroup(%q) = %v, want %v", rg, got, want)
+		}
+	}
+	if !scopes.IncludesResource("Microsoft.Compute/virtualMachines", "westeurope") {
+		t.Error("virtual machines in westeurope should be in scope")
+	}
+	if scopes.IncludesResource("Microsoft.Network/virtualNetworks", "westeurope") {
+		t.Error("networks should not be in scope")
+	}
+	if scopes.IncludesResource("Microsoft.Compute/virtualMachines", "northeurope") {
+		t.Error("northeurope should not be in scope")
+	}
+	if !(Scopes{}).IncludesResourceGroup("any") || !(Scope{}).IsZero() || file.IsZero() {
+		t.Error("empty scopes include everything")
+	}
+}
+
+func TestNewFromString_Scope(t *testing.T) {
+	got, err := NewFromString(`
+scope:
+  resourceGroups: ["prod-*"]
+  excludeTypes: ["Microsoft.Network/*"]
+rules: []
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Scope{ResourceGroups: []string{"prod-*"}, ExcludeTypes: []string{"Microsoft.Network/*"}}
+	if !reflect.DeepEqual(got.Scope, want) {
+		t.Errorf("Scope = %v, want %v", got.Scope, want)
+	}
+
+	merged := TagRules{}
+	merged.merge(TagRules{Scope: &Scope{ResourceGroups: []string{"a"}}}, "a.yaml")
+	merged.merge(TagRules{Scope: &Scope{ResourceGroups: []string{"b"}, Regions: []string{"westeurope"}}}, "b.yaml")
+	merged.merge(TagRules{Scope: &Scope{ResourceGroups: []string{"a", "b"}}}, "")
+	wantScopes := Scopes{
+		{ResourceGroups: []string{"a", "b"}},
+		{ResourceGroups: []string{"a"}},
+		{ResourceGroups: []string{"b"}, Regions: []string{"westeurope"}},
+	}
+	if !reflect.DeepEqual(merged.AllScopes(), wantScopes) {
+		t.Errorf("merged AllScopes() = %v, want %v", merged.AllScopes(), wantScopes)
+	}
+	if merged.AllScopes().IncludesResourceGroup("a") || merged.AllScopes().IncludesResourceGroup("b") {
+		t.Error("included files widened the scope of the rules")
+	}
+}
@@ -0,0 +1,191 @@
+package rules
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureExt is the extension of detached signatures of rules files
+const SignatureExt = ".sig"
+
+// WithPublicKeys sets keys that verify detached signatures of rules files. Every loaded file, including the included
+// ones, must have a signature verified by any of the keys
+func WithPublicKeys(keys ...ed25519.PublicKey) Option {
+	return func(l *loader) {
+		l.publicKeys = append(l.publicKeys, keys...)
+	}
+}
+
+// WithDigest pins sha256 digests, in hex, of rules files. Every loaded file, including the included ones, must have
+// one of the digests
+func WithDigest(digests ...string) Option {
+	return func(l *loader) {
+		if l.digests == nil {
+			l.digests = make(map[string]bool)
+		}
+		for _, digest := range digests {
+			l.digests[strings.ToLower(digest)] = true
+		}
+	}
+}
+
+// RequireSigned makes unsigned rules files, including the included ones, an error. It is implied by public keys
+func RequireSigned() Option {
+	return func(l *loader) {
+		l.requireSigned = true
+	}
+}
+
+// checkDigest checks that the digest of dat is one of the pinned digests, if digests are pinned. It returns true if
+// the digest was checked
+func (l *loader) checkDigest(dat []byte) (bool, error) {
+	if len(l.digests) == 0 {
+		return false, nil
+	}
+	digest := Digest(dat)
+	if !l.digests[digest] {
+		pinned := make([]string, 0, len(l.digests))
+		for d := range l.digests {
+			pinned = append(pinned, d)
+		}
+		sort.Strings(pinned)
+		return false, errors.Errorf("sha256 of the file is %s, expected %s", digest, strings.Join(pinned, " or "))
+	}
+	return true, nil
+}
+
+// verify checks the digest and the signature of the file path with content dat. It returns true if the file was
+// verified by a digest or a signature. Signatures and digests cover the file as written, before parameters are
+// substituted
+func (l *loader) verify(path string, dat []byte) (bool, error) {
+	verified, err := l.checkDigest(dat)
+	if err != nil {
+		return false, err
+	}
+	if len(l.publicKeys) == 0 && !l.requireSigned {
+		return verified, nil
+	}
+
+	sig, err := ioutil.ReadFile(path + SignatureExt)
+	if os.IsNotExist(err) {
+		return false, errors.Errorf("file is not signed, expected a signature in %s", path+SignatureExt)
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "can't read the signature")
+	}
+	if len(l.publicKeys) == 0 {
+		return false, errors.New("file is signed, but no public key is given to verify the signature")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return false, errors.Wrap(err, "can't decode the signature")
+	}
+	for _, key := range l.publicKeys {
+		if ed25519.Verify(key, dat, signature) {
+			return true, nil
+		}
+	}
+	return false, errors.Errorf("signature in %s does not match the file", path+SignatureExt)
+}
+
+// Digest returns sha256 of dat in hex
+func Digest(dat []byte) string {
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignFile signs filename with key and writes the signature next to it. It returns the name of the signature file
+func SignFile(filename string, key ed25519.PrivateKey) (string, error) {
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errors.Wrap(err, "error opening the file")
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, dat))
+	if err := ioutil.WriteFile(filename+SignatureExt, []byte(signature+"\n"), 0644); err != nil {
+		return "", errors.Wrap(err, "can't write the signature")
+	}
+	return filename + SignatureExt, nil
+}
+
+// GenerateKeys generates an ed25519 key pair and writes it in PEM to prefix.key and prefix.pub
+func GenerateKeys(prefix string) error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "can't generate keys")
+	}
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal the private key")
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal the public key")
+	}
+
+	if err := ioutil.WriteFile(prefix+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}), 0600); err != nil {
+		return errors.Wrap(err, "can't write the private key")
+	}
+	if err := ioutil.WriteFile(prefix+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}), 0644); err != nil {
+		return errors.Wrap(err, "can't write the public key")
+	}
+	return nil
+}
+
+// LoadPrivateKey reads a PEM encoded ed25519 private key from filename
+func LoadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(filename, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse the private key in %s", filename)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("%s is not an ed25519 private key", filename)
+	}
+	return private, nil
+}
+
+// LoadPublicKey reads a PEM encoded ed25519 public key from filename
+func LoadPublicKey(filename string) (ed25519.PublicKey, error) {
+	der, err := readPEM(filename, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse the public key in %s", filename)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("%s is not an ed25519 public key", filename)
+	}
+	return public, nil
+}
+
+func readPEM(filename, blockType string) ([]byte, error) {
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening the file")
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil || block.Type != blockType {
+		return nil, errors.Errorf("%s does not contain a PEM encoded %s", filename, strings.ToLower(blockType))
+	}
+	return block.Bytes, nil
+}
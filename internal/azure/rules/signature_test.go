@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewFromFile_Signatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := filepath.Join(dir, "main.json")
+	includedFile := filepath.Join(dir, "included.json")
+	mustWrite := func(filename, content string) {
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite(main, `{"include": ["included.json"], "rules": []}`)
+	mustWrite(includedFile, `{"rules": []}`)
+
+	if err := GenerateKeys(filepath.Join(dir, "signing")); err != nil {
+		t.Fatalf("GenerateKeys() error = %v", err)
+	}
+	private, err := LoadPrivateKey(filepath.Join(dir, "signing.key"))
+	if err != nil {
+		t.Fatalf("LoadPrivateKey() error = %v", err)
+	}
+	public, err := LoadPublicKey(filepath.Join(dir, "signing.pub"))
+	if err != nil {
+		t.Fatalf("LoadPublicKey() error = %v", err)
+	}
+	if _, err := LoadPublicKey(filepath.Join(dir, "signing.key")); err == nil {
+		t.Errorf("LoadPublicKey() of a private key expected an error")
+	}
+
+	if _, err := SignFile(main, private); err != nil {
+		t.Fatalf("SignFile() error = %v", err)
+	}
+	dat, _ := ioutil.ReadFile(main)
+	included, _ := ioutil.ReadFile(includedFile)
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{name: "signatures not checked without keys", opts: nil},
+		{name: "unsigned include", opts: []Option{WithPublicKeys(public)}, wantErr: "included.json: file is not signed"},
+		{name: "unsigned include required", opts: []Option{WithPublicKeys(public), RequireSigned()}, wantErr: "included.json: file is not signed"},
+		{name: "pinned digests", opts: []Option{WithDigest(Digest(dat), Digest(included))}},
+		{name: "include not pinned", opts: []Option{WithDigest(Digest(dat))}, wantErr: "included.json: sha256 of the file is"},
+		{name: "wrong digest", opts: []Option{WithDigest(Digest([]byte("other")))}, wantErr: "sha256 of the file is"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFromFile(main, tt.opts...)
+			if (err != nil) != (tt.wantErr != "") {
+				t.Fatalf("NewFromFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewFromFile() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := SignFile(includedFile, private); err != nil {
+		t.Fatalf("SignFile() error = %v", err)
+	}
+	if _, err := NewFromFile(main, WithPublicKeys(public)); err != nil {
+		t.Errorf("NewFromFile() of signed files error = %v", err)
+	}
+
+	// parameters would change a signed file after its signature is verified
+	mustWrite(includedFile, `{"rules": [{"name": "${name:-default}"}]}`)
+	if _, err := SignFile(includedFile, private); err != nil {
+		t.Fatalf("SignFile() error = %v", err)
+	}
+	if _, err := NewFromFile(main, WithPublicKeys(public), WithParams(map[string]string{"name": "other"})); err == nil || !strings.Contains(err.Error(), "can't be used in a signed or pinned file") {
+		t.Errorf("NewFromFile() of a signed file with parameters error = %v", err)
+	}
+	if _, err := NewFromFile(main); err != nil {
+		t.Errorf("NewFromFile() of an unverified file with parameters error = %v", err)
+	}
+
+	mustWrite(main, `{"include": ["included.json"], "rules": [{"name": "tampered"}]}`)
+	if _, err := NewFromFile(main, WithPublicKeys(public)); err == nil || !strings.Contains(err.Error(), "does not match the file") {
+		t.Errorf("NewFromFile() of a tampered file error = %v", err)
+	}
+}
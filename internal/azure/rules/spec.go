@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParamType is the type of a parameter of conditions and actions
+type ParamType string
+
+// Types of parameters
+const (
+	StringParam     ParamType = "string" // a string, numbers and booleans are accepted as well
+	StringListParam ParamType = "list"   // a list of strings, a single string is a list of one
+	StringMapParam  ParamType = "map"    // an object with string values
+	FileParam       ParamType = "file"   // path of a file, relative to the rules file defining it
+)
+
+// Param describes a parameter of a condition or an action
+type Param struct {
+	Name        string    `json:"name"`
+	Type        ParamType `json:"type"`
+	Required    bool      `json:"required,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+// Spec describes a type of conditions or actions and its parameters
+type Spec struct {
+	Type        string                                  `json:"type"`
+	Description string                                  `json:"description,omitempty"`
+	Params      []Param                                 `json:"params,omitempty"`
+	Validate    func(item map[string]interface{}) error `json:"-"` // additional validation of the parameters, optional
+}
+
+// Check checks that item has the parameters of the spec, with the right types. Parameters that are not
+// in the spec are accepted in version 1 of the rules, and are an error since version 2
+func (s Spec) Check(item map[string]interface{}, version int) error {
+	if err := s.checkParams(item, version); err != nil {
+		return err
+	}
+	return s.validate(item)
+}
+
+func (s Spec) checkParams(item map[string]interface{}, version int) error {
+	var errs Errors
+
+	params := make(map[string]Param, len(s.Params))
+	for _, param := range s.Params {
+		params[param.Name] = param
+		value, ok := item[param.Name]
+		if !ok {
+			if param.Required {
+				errs.add(errors.Errorf("%s: parameter %q is required", s.Type, param.Name))
+			}
+			continue
+		}
+		if !param.Type.accepts(value) {
+			errs.add(errors.Errorf("%s: parameter %q must be a %s", s.Type, param.Name, param.Type))
+		}
+	}
+
+	if version >= Version2 {
+		for _, key := range sortedKeys(item) {
+			if _, ok := params[key]; !ok && key != "type" {
+				errs.add(errors.Errorf("%s: unknown parameter %q, expected one of %s", s.Type, key, s.paramNames()))
+			}
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// validate runs the Validate hook of the spec
+func (s Spec) validate(item map[string]interface{}) error {
+	if s.Validate == nil {
+		return nil
+	}
+	return prefixErrors(s.Validate(item), s.Type)
+}
+
+func (s Spec) paramNames() string {
+	names := make([]string, len(s.Params))
+	for i, param := range s.Params {
+		names[i] = param.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// accepts returns true if value, as unmarshalled from JSON, has the type t
+func (t ParamType) accepts(value interface{}) bool {
+	switch t {
+	case StringParam, FileParam:
+		_, ok := toString(value)
+		return ok
+	case StringListParam:
+		if _, ok := toString(value); ok {
+			return true
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			_, ok := value.([]string)
+			return ok
+		}
+		for _, elem := range list {
+			if _, ok := toString(elem); !ok {
+				return false
+			}
+		}
+		return true
+	case StringMapParam:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			_, ok := value.(map[string]string)
+			return ok
+		}
+		for _, elem := range m {
+			if _, ok := toString(elem); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Validate checks the conditions and actions of the rules against specs of the supported types. In version 1 of the rules
+// unknown types and wrong parameters are returned as warnings, as they used to be ignored. Errors of the Validate hooks
+// of the specs are always errors
+func (t TagRules) Validate(conditions, actions map[string]Spec) (warnings []error, err error) {
+	var errs, warns Errors
+	check := func(kind string, item map[string]interface{}, specs map[string]Spec, prefix string) {
+		typ := getString(item, "type")
+		spec, ok := specs[typ]
+		var problem error
+		if !ok {
+			problem = errors.Errorf("unknown %s type %q", kind, typ)
+		} else {
+			problem = spec.checkParams(item, t.GetVersion())
+		}
+		if problem != nil {
+			if t.GetVersion() < Version2 {
+				warns.add(prefixErrors(problem, prefix))
+			} else {
+				errs.add(prefixErrors(problem, prefix))
+			}
+			return
+		}
+		errs.add(prefixErrors(spec.validate(item), prefix))
+	}
+
+	for i, rule := range t.Rules {
+		for j, cond := range rule.Conditions {
+			check("condition", cond, conditions, fmt.Sprintf("rule %d (%q): condition %d", i, rule.Name, j))
+		}
+		for j, action := range rule.Actions {
+			check("action", action, actions, fmt.Sprintf("rule %d (%q): action %d", i, rule.Name, j))
+		}
+	}
+	return warns, errs.errorOrNil()
+}
+
+// ResolveFiles returns t with the file parameters of conditions and actions, described by the specs, relative to the
+// directory of the rules file defining them instead of the working directory
+func (t TagRules) ResolveFiles(conditions, actions map[string]Spec) TagRules {
+	resolve := func(item map[string]interface{}, specs map[string]Spec, dir string) map[string]interface{} {
+		resolved := make(map[string]interface{}, len(item))
+		for k, v := range item {
+			resolved[k] = v
+		}
+		for _, param := range specs[getString(item, "type")].Params {
+			if file, ok := item[param.Name].(string); ok && param.Type == FileParam && file != "" && !filepath.IsAbs(file) {
+				resolved[param.Name] = filepath.Join(dir, file)
+			}
+		}
+		return resolved
+	}
+
+	resolved := t
+	resolved.Rules = make([]Rule, len(t.Rules))
+	for i, rule := range t.Rules {
+		conds, acts := rule.Conditions, rule.Actions
+		rule.Conditions, rule.Actions = make([]ConditionItem, len(conds)), make([]ActionItem, len(acts))
+		for j, cond := range conds {
+			rule.Conditions[j] = resolve(cond, conditions, rule.Dir)
+		}
+		for j, action := range acts {
+			rule.Actions[j] = resolve(action, actions, rule.Dir)
+		}
+		resolved.Rules[i] = rule
+	}
+	return resolved
+}
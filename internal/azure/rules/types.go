@@ -3,7 +3,8 @@ package rules
 import (
 	"bytes"
 	"encoding/json"
-	"io/ioutil"
+	"sort"
+	"strings"
 	"unicode"
 
 	"github.com/ghodss/yaml"
@@ -11,54 +12,199 @@ import (
 	"github.com/pkg/errors"
 )
 
-// NewFromFile reads filename and returns TagRules
-func NewFromFile(filename string) (TagRules, error) {
-	dat, err := ioutil.ReadFile(filename)
+// NewFromFile reads filename and returns TagRules. Included files are resolved relative to filename
+func NewFromFile(filename string, opts ...Option) (TagRules, error) {
+	l := newLoader(opts...)
+	t, err := l.loadFile(filename)
 	if err != nil {
-		return TagRules{}, errors.Wrap(err, "error opening the file")
+		return TagRules{}, err
 	}
 
-	return NewFromString(string(dat))
+	return t.resolve()
 }
 
-// NewFromString parses rulesDef and returns TagRules
-func NewFromString(rulesDef string) (TagRules, error) {
-	return parseRulesDefinitions(rulesDef)
+// NewFromString parses rulesDef and returns TagRules. Parameters like ${NAME} are expanded from opts and the environment.
+// Included files are resolved relative to the working directory
+func NewFromString(rulesDef string, opts ...Option) (TagRules, error) {
+	l := newLoader(opts...)
+	if l.requireSigned {
+		return TagRules{}, errors.New("signatures can only be verified for rules files")
+	}
+	verified, err := l.checkDigest([]byte(rulesDef))
+	if err != nil {
+		return TagRules{}, err
+	}
+	t, err := l.load(rulesDef, source{verified: verified})
+	if err != nil {
+		return TagRules{}, err
+	}
+
+	return t.resolve()
 }
 
+// Versions of the rules definitions
+const (
+	Version1      = 1 // parameters of conditions and actions are strings
+	Version2      = 2 // parameters are typed, they can be lists and objects
+	LatestVersion = Version2
+)
+
 // TagRules represents rules parsed from a rules definition
 type TagRules struct {
-	DryRun *bool  `json:"dryrun,omitempty"`
-	Rules  []Rule `json:"rules"`
+	Version       int                        `json:"version,omitempty"`
+	DryRun        *bool                      `json:"dryrun,omitempty"`
+	Include       []string                   `json:"include,omitempty"`       // files, directories or globs with rules to include
+	Vars          map[string]string          `json:"vars,omitempty"`          // variables substituted for ${vars.name} in conditions and actions
+	ConditionSets map[string][]ConditionItem `json:"conditionSets,omitempty"` // named conditions referenced by {"type": "ref", "id": name}
+	Scope         *Scope                     `json:"scope,omitempty"`         // resources scanned for the rules, all if not set
+	Rules         []Rule                     `json:"rules"`
+
+	IncludedScopes Scopes `json:"-"` // scopes of the included files, which narrow Scope, set by the loader
 }
 
+// Rule modes
+const (
+	ModeEnforce  = "enforce"  // actions of the rule are executed
+	ModeAudit    = "audit"    // matching resources are only reported as non-compliant
+	ModeDisabled = "disabled" // the rule is not evaluated at all
+)
+
+// Rule severities
+var severities = []string{"info", "low", "medium", "high", "critical"}
+
 // Rule represnts single rule
 type Rule struct {
-	Name       string          `json:"name,omitempty"`
-	Conditions []ConditionItem `json:"conditions"`
-	Actions    []ActionItem    `json:"actions"`
+	Name        string            `json:"name,omitempty"`
+	Mode        string            `json:"mode,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`    // team or person responsible for the rule
+	Ticket      string            `json:"ticket,omitempty"`   // ticket or change request behind the rule
+	Severity    string            `json:"severity,omitempty"` // one of info, low, medium, high, critical
+	Labels      map[string]string `json:"labels,omitempty"`
+	Conditions  []ConditionItem   `json:"conditions"`
+	Actions     []ActionItem      `json:"actions"`
+	Dir         string            `json:"-"` // directory of the rules file defining the rule, set by the loader
 }
 
-// ConditionItem represnts one condition
-type ConditionItem map[string]string
+// IsMetadataKey returns true if key can be used in MetadataValue
+func IsMetadataKey(key string) bool {
+	switch key {
+	case "rule", "owner", "ticket", "severity", "mode":
+		return true
+	}
+	return strings.HasPrefix(key, "label:") && len(key) > len("label:")
+}
 
-// GetType retrurn the type of the condition
-func (p ConditionItem) GetType() string {
-	if val, ok := p["type"]; ok {
-		return val
+// MetadataValue returns the value of the metadata key of the rule. Keys are rule, owner, ticket, severity, mode and label:<name>
+func (r Rule) MetadataValue(key string) string {
+	switch key {
+	case "rule":
+		return r.Name
+	case "owner":
+		return r.Owner
+	case "ticket":
+		return r.Ticket
+	case "severity":
+		return r.Severity
+	case "mode":
+		return r.GetMode()
+	}
+	if strings.HasPrefix(key, "label:") {
+		return r.Labels[strings.TrimPrefix(key, "label:")]
 	}
 	return ""
 }
 
-// ActionItem represnts a single action
-type ActionItem map[string]string
+// MetadataString returns the metadata of the rule in a key=value form, used in reports
+func (r Rule) MetadataString() string {
+	var fields []string
+	for _, key := range []string{"owner", "ticket", "severity"} {
+		if value := r.MetadataValue(key); value != "" {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	labels := make([]string, 0, len(r.Labels))
+	for key := range r.Labels {
+		labels = append(labels, key)
+	}
+	sort.Strings(labels)
+	for _, key := range labels {
+		fields = append(fields, "label:"+key+"="+r.Labels[key])
+	}
+	return strings.Join(fields, " ")
+}
+
+// GetMode returns the mode of the rule, enforce if not set
+func (r Rule) GetMode() string {
+	if r.Mode == "" {
+		return ModeEnforce
+	}
+	return r.Mode
+}
+
+// ConditionItem represnts one condition. In version 1 of the rules all parameters are strings,
+// version 2 allows lists of strings and objects as well
+type ConditionItem map[string]interface{}
+
+// GetType retrurn the type of the condition
+func (p ConditionItem) GetType() string {
+	return getString(p, "type")
+}
+
+// GetString returns the parameter key as a string
+func (p ConditionItem) GetString(key string) string {
+	return getString(p, key)
+}
+
+// GetStrings returns the parameter key as a list of strings, a single string is a list of one
+func (p ConditionItem) GetStrings(key string) []string {
+	return getStrings(p, key)
+}
+
+// GetMap returns the parameter key as a map of strings
+func (p ConditionItem) GetMap(key string) map[string]string {
+	return getMap(p, key)
+}
+
+// ActionItem represnts a single action. In version 1 of the rules all parameters are strings,
+// version 2 allows lists of strings and objects as well
+type ActionItem map[string]interface{}
 
 // GetType retrurn the type of the action
 func (p ActionItem) GetType() string {
-	if val, ok := p["type"]; ok {
-		return val
+	return getString(p, "type")
+}
+
+// GetString returns the parameter key as a string
+func (p ActionItem) GetString(key string) string {
+	return getString(p, key)
+}
+
+// GetStrings returns the parameter key as a list of strings, a single string is a list of one
+func (p ActionItem) GetStrings(key string) []string {
+	return getStrings(p, key)
+}
+
+// GetMap returns the parameter key as a map of strings
+func (p ActionItem) GetMap(key string) map[string]string {
+	return getMap(p, key)
+}
+
+// String returns the action in the form type(key=value, ...), used in notifications
+func (p ActionItem) String() string {
+	var params []string
+	for _, key := range sortedKeys(p) {
+		if key == "type" {
+			continue
+		}
+		value, ok := toString(p[key])
+		if !ok {
+			dat, _ := json.Marshal(p[key])
+			value = string(dat)
+		}
+		params = append(params, key+"="+value)
 	}
-	return ""
+	return p.GetType() + "(" + strings.Join(params, ", ") + ")"
 }
 
 var jsonPrefix = []byte("{")
@@ -75,9 +221,53 @@ func parseRulesDefinitions(rules string) (TagRules, error) {
 			return TagRules{}, errors.Wrap(err, "can't unmarshal yaml rules")
 		}
 	}
+	if err := rulesDef.validate(); err != nil {
+		return TagRules{}, err
+	}
 	return rulesDef, nil
 }
 
+// GetVersion returns the version of the rules, 1 if not set
+func (t TagRules) GetVersion() int {
+	if t.Version == 0 {
+		return Version1
+	}
+	return t.Version
+}
+
+// validate checks if parsed rules are semantically correct
+func (t TagRules) validate() error {
+	if t.GetVersion() < Version1 || t.GetVersion() > LatestVersion {
+		return errors.Errorf("unsupported version %d, expected %d to %d", t.Version, Version1, LatestVersion)
+	}
+	if t.GetVersion() == Version1 {
+		if err := t.checkStringParams(); err != nil {
+			return err
+		}
+	}
+
+	for i, rule := range t.Rules {
+		switch rule.GetMode() {
+		case ModeEnforce, ModeAudit, ModeDisabled:
+		default:
+			return errors.Errorf("rule %d (%q): unknown mode %q", i, rule.Name, rule.Mode)
+		}
+		if rule.Severity != "" && !contains(severities, rule.Severity) {
+			return errors.Errorf("rule %d (%q): unknown severity %q, expected one of %s", i, rule.Name, rule.Severity, strings.Join(severities, ", "))
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 // hasJSONPrefix returns true if the provided buffer appears to start with
 // a JSON open brace.
 func hasJSONPrefix(buf []byte) bool {
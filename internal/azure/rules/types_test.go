@@ -1,6 +1,7 @@
 package rules
 
 import (
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -59,10 +60,64 @@ rules:
 				}
 				]
 			}`
-	empty      = `{}`
-	onlyDryRun = `{"dryrun": true}`
-	wrongJSON  = `{ew2`
-	wrongYaml  = `223322`
+	modes = `
+rules:
+- name: audited
+  mode: audit
+  conditions:
+  - type: tagNotExists
+    tag: env
+  actions:
+  - type: addTag
+    tag: env
+    value: dev
+`
+	metadata = `
+rules:
+- name: owned
+  description: Every resource needs a cost center
+  owner: finops
+  ticket: FIN-42
+  severity: high
+  labels:
+    policy: costs
+  conditions:
+  - type: tagNotExists
+    tag: costcenter
+  actions:
+  - type: addTag
+    tag: costcenter
+    value: unknown
+`
+	version2 = `
+version: 2
+rules:
+- name: production
+  conditions:
+  - type: tagValueIn
+    tag: env
+    values: [prod, production, prd]
+  actions:
+  - type: addTags
+    tags:
+      owner: platform
+      tier: 1
+`
+	listInVersion1 = `
+rules:
+- name: production
+  conditions:
+  - type: tagValueIn
+    tag: env
+    values: [prod, production]
+`
+	wrongVersion  = `{"version": 3}`
+	wrongSeverity = `{"rules": [{"name": "name", "severity": "urgent"}]}`
+	wrongMode     = `{"rules": [{"name": "name", "mode": "sometimes"}]}`
+	empty         = `{}`
+	onlyDryRun    = `{"dryrun": true}`
+	wrongJSON     = `{ew2`
+	wrongYaml     = `223322`
 )
 
 var (
@@ -76,8 +131,47 @@ var (
 			},
 		},
 	}}
+
+	modesWant = TagRules{Rules: []Rule{
+		{Name: "audited", Mode: ModeAudit, Conditions: []ConditionItem{
+			{"type": "tagNotExists", "tag": "env"},
+		},
+			Actions: []ActionItem{
+				{"type": "addTag", "tag": "env", "value": "dev"},
+			},
+		},
+	}}
 )
 
+var version2Want = TagRules{Version: Version2, Rules: []Rule{
+	{
+		Name: "production",
+		Conditions: []ConditionItem{
+			{"type": "tagValueIn", "tag": "env", "values": []interface{}{"prod", "production", "prd"}},
+		},
+		Actions: []ActionItem{
+			{"type": "addTags", "tags": map[string]interface{}{"owner": "platform", "tier": float64(1)}},
+		},
+	},
+}}
+
+var metadataWant = TagRules{Rules: []Rule{
+	{
+		Name:        "owned",
+		Description: "Every resource needs a cost center",
+		Owner:       "finops",
+		Ticket:      "FIN-42",
+		Severity:    "high",
+		Labels:      map[string]string{"policy": "costs"},
+		Conditions: []ConditionItem{
+			{"type": "tagNotExists", "tag": "costcenter"},
+		},
+		Actions: []ActionItem{
+			{"type": "addTag", "tag": "costcenter", "value": "unknown"},
+		},
+	},
+}}
+
 var dryRunFalse = false
 var dryRunTrue = true
 
@@ -95,6 +189,13 @@ func TestNewFromString(t *testing.T) {
 		{name: "only dryrun defined", args: args{rulesDef: onlyDryRun}, want: TagRules{DryRun: &dryRunTrue}, wantErr: false},
 		{name: "one rule", args: args{rulesDef: two}, want: twoRulesWant, wantErr: false},
 		{name: "one rule yaml", args: args{rulesDef: yamlTwo}, want: twoRulesWant, wantErr: false},
+		{name: "rule with mode", args: args{rulesDef: modes}, want: modesWant, wantErr: false},
+		{name: "rule with metadata", args: args{rulesDef: metadata}, want: metadataWant, wantErr: false},
+		{name: "version 2", args: args{rulesDef: version2}, want: version2Want, wantErr: false},
+		{name: "list in version 1", args: args{rulesDef: listInVersion1}, want: TagRules{}, wantErr: true},
+		{name: "unknown version", args: args{rulesDef: wrongVersion}, want: TagRules{}, wantErr: true},
+		{name: "unknown severity", args: args{rulesDef: wrongSeverity}, want: TagRules{}, wantErr: true},
+		{name: "unknown mode", args: args{rulesDef: wrongMode}, want: TagRules{}, wantErr: true},
 		{name: "wrong json", args: args{rulesDef: wrongJSON}, want: TagRules{}, wantErr: true},
 		{name: "wrong yaml", args: args{rulesDef: wrongYaml}, want: TagRules{}, wantErr: true},
 	}
@@ -112,3 +213,123 @@ func TestNewFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestRule_GetMode(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want string
+	}{
+		{name: "default", rule: Rule{}, want: ModeEnforce},
+		{name: "audit", rule: Rule{Mode: ModeAudit}, want: ModeAudit},
+		{name: "disabled", rule: Rule{Mode: ModeDisabled}, want: ModeDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.GetMode(); got != tt.want {
+				t.Errorf("GetMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRule_MetadataValue(t *testing.T) {
+	rule := metadataWant.Rules[0]
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "rule", want: "owned"},
+		{key: "owner", want: "finops"},
+		{key: "ticket", want: "FIN-42"},
+		{key: "severity", want: "high"},
+		{key: "mode", want: ModeEnforce},
+		{key: "label:policy", want: "costs"},
+		{key: "label:missing", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if !IsMetadataKey(tt.key) {
+				t.Errorf("IsMetadataKey(%q) = false", tt.key)
+			}
+			if got := rule.MetadataValue(tt.key); got != tt.want {
+				t.Errorf("MetadataValue(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	if IsMetadataKey("label:") || IsMetadataKey("color") {
+		t.Errorf("IsMetadataKey() accepted an unknown key")
+	}
+	if got, want := rule.MetadataString(), "owner=finops ticket=FIN-42 severity=high label:policy=costs"; got != want {
+		t.Errorf("MetadataString() = %v, want %v", got, want)
+	}
+}
+
+func TestConditionItem_Getters(t *testing.T) {
+	cond := version2Want.Rules[0].Conditions[0]
+	if got := cond.GetString("tag"); got != "env" {
+		t.Errorf("GetString() = %v, want env", got)
+	}
+	if got, want := cond.GetStrings("values"), []string{"prod", "production", "prd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetStrings() = %v, want %v", got, want)
+	}
+	if got, want := cond.GetStrings("tag"), []string{"env"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetStrings() = %v, want %v", got, want)
+	}
+	action := version2Want.Rules[0].Actions[0]
+	if got, want := action.GetMap("tags"), map[string]string{"owner": "platform", "tier": "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetMap() = %v, want %v", got, want)
+	}
+}
+
+func TestSpec_Check(t *testing.T) {
+	spec := Spec{Type: "tagValueIn", Params: []Param{
+		{Name: "tag", Type: StringParam, Required: true},
+		{Name: "values", Type: StringListParam, Required: true},
+	}}
+	tests := []struct {
+		name    string
+		item    map[string]interface{}
+		version int
+		wantErr bool
+	}{
+		{name: "valid", item: map[string]interface{}{"type": "tagValueIn", "tag": "env", "values": []interface{}{"prod"}}, version: Version2},
+		{name: "missing parameter", item: map[string]interface{}{"type": "tagValueIn", "tag": "env"}, version: Version2, wantErr: true},
+		{name: "wrong type", item: map[string]interface{}{"type": "tagValueIn", "tag": "env", "values": map[string]interface{}{}}, version: Version2, wantErr: true},
+		{name: "unknown parameter", item: map[string]interface{}{"type": "tagValueIn", "tag": "env", "values": []interface{}{}, "value": "prod"}, version: Version2, wantErr: true},
+		{name: "unknown parameter in version 1", item: map[string]interface{}{"type": "tagValueIn", "tag": "env", "values": "prod", "value": "prod"}, version: Version1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := spec.Check(tt.item, tt.version); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTagRules_ResolveFiles(t *testing.T) {
+	specs := map[string]Spec{"script": {Type: "script", Params: []Param{{Name: "file", Type: FileParam}, {Name: "source", Type: StringParam}}}}
+	rules := TagRules{Rules: []Rule{{Dir: "/rules", Actions: []ActionItem{
+		{"type": "script", "file": "scripts/tags.star", "source": "relative"},
+		{"type": "script", "file": "/abs/tags.star"},
+		{"type": "other", "file": "kept"},
+	}}}}
+
+	got := rules.ResolveFiles(nil, specs)
+	want := []ActionItem{
+		{"type": "script", "file": filepath.Join("/rules", "scripts/tags.star"), "source": "relative"},
+		{"type": "script", "file": "/abs/tags.star"},
+		{"type": "other", "file": "kept"},
+	}
+	if !reflect.DeepEqual(got.Rules[0].Actions, want) {
+		t.Errorf("ResolveFiles() = %v, want %v", got.Rules[0].Actions, want)
+	}
+	if rules.Rules[0].Actions[0]["file"] != "scripts/tags.star" {
+		t.Errorf("ResolveFiles() modified the rules")
+	}
+}
@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// getString returns the parameter key of item as a string. Numbers and booleans are formatted
+func getString(item map[string]interface{}, key string) string {
+	s, _ := toString(item[key])
+	return s
+}
+
+// getStrings returns the parameter key of item as a list of strings
+func getStrings(item map[string]interface{}, key string) []string {
+	switch v := item[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, elem := range v {
+			if s, ok := toString(elem); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	if s, ok := toString(item[key]); ok {
+		return []string{s}
+	}
+	return nil
+}
+
+// getMap returns the parameter key of item as a map of strings
+func getMap(item map[string]interface{}, key string) map[string]string {
+	switch v := item[key].(type) {
+	case map[string]string:
+		return v
+	case map[string]interface{}:
+		m := make(map[string]string, len(v))
+		for k, elem := range v {
+			if s, ok := toString(elem); ok {
+				m[k] = s
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// toString converts scalar values to strings
+func toString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// checkStringParams checks that all parameters of conditions and actions are strings, as required by version 1
+func (t TagRules) checkStringParams() error {
+	check := func(item map[string]interface{}) error {
+		for _, key := range sortedKeys(item) {
+			if _, ok := item[key].(string); !ok {
+				return errors.Errorf("parameter %q must be a string, lists and objects need version: %d", key, Version2)
+			}
+		}
+		return nil
+	}
+
+	var errs Errors
+	for id, conditions := range t.ConditionSets {
+		for j, cond := range conditions {
+			errs.add(prefixErrors(check(cond), fmt.Sprintf("condition set %q: condition %d", id, j)))
+		}
+	}
+	for i, rule := range t.Rules {
+		for j, cond := range rule.Conditions {
+			errs.add(prefixErrors(check(cond), fmt.Sprintf("rule %d (%q): condition %d", i, rule.Name, j)))
+		}
+		for j, action := range rule.Actions {
+			errs.add(prefixErrors(check(action), fmt.Sprintf("rule %d (%q): action %d", i, rule.Name, j)))
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// substituteStrings returns a copy of v with replace applied to all strings in it
+func substituteStrings(v interface{}, replace func(string) string) interface{} {
+	switch v := v.(type) {
+	case string:
+		return replace(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = substituteStrings(elem, replace)
+		}
+		return list
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[k] = substituteStrings(elem, replace)
+		}
+		return m
+	}
+	return v
+}
+
+func sortedKeys(item map[string]interface{}) []string {
+	keys := make([]string, 0, len(item))
+	for key := range item {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,28 +2,146 @@ package azure
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
 	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
 	"github.com/pkg/errors"
 )
 
 // ResourceGroupScanner represents resource group scanner that scans all resources in a resource group
 type ResourceGroupScanner struct {
-	Session         *session.AzureSession
-	ResourcesClient *resources.Client
-	GroupsClient    *resources.GroupsClient
+	Session          *session.AzureSession
+	ResourcesClient  *resources.Client
+	GroupsClient     *resources.GroupsClient
+	ManagementGroups []string     // management groups of the subscription, set on the resources
+	Scope            rules.Scopes // resource groups and resources out of the scope are never listed
+	Concurrency      int          // resource groups scanned at the same time, DefaultConcurrency if not positive
+	Partial          bool         // resource groups failing to be scanned are skipped instead of failing GetResources
 }
 
+const (
+	// DefaultConcurrency is the default number of resource groups scanned at the same time
+	DefaultConcurrency = 8
+	// DefaultRetries is the default number of retries of throttled and failed requests
+	DefaultRetries = autorest.DefaultRetryAttempts
+	// DefaultRetryBackoff is the default delay before the first retry, doubled before each next one
+	DefaultRetryBackoff = autorest.DefaultRetryDuration
+)
+
 // Scanner represents generic scanner of Azure resource groups
 type Scanner interface {
 	GetResources() ([]Resource, error)
 	GetResourcesByResourceGroup(string) ([]Resource, error)
 	GetGroups() ([]string, error)
 	GetResourceGroupTags(string) (map[string]*string, error)
+	// Fields returns the optional fields of resources filled by the scanner, see CheckFields
+	Fields() []string
+	// ListGroups returns the resource groups of all the subscriptions with their tags, in bulk
+	ListGroups() ([]ResourceGroup, error)
+	// Version returns the version of the SDK and of the API the scanner reads resources with
+	Version() string
+}
+
+// ResourceGroup is a resource group of a subscription
+type ResourceGroup struct {
+	Subscription string
+	Name         string
+	Tags         map[string]*string
+}
+
+// ResourceStreamer is a Scanner which sends the resources to a channel as they are scanned, so that they can be
+// processed before the whole scan is done
+type ResourceStreamer interface {
+	Scanner
+	// StreamResources sends the resources to out, waiting while out is full. It stops when ctx is done, and
+	// doesn't close out
+	StreamResources(ctx context.Context, out chan<- Resource) error
+}
+
+// Optional fields of resources, which only some scans fill
+const (
+	FieldManagementGroups = "managementGroups" // only known when the subscriptions are selected by management group
+	FieldManagedBy        = "managedBy"
+	FieldIdentity         = "identity"
+	FieldCreatedTime      = "createdTime"
+	FieldChangedTime      = "changedTime"
+)
+
+// armFields are the optional fields filled by listing the resources of resource groups
+var armFields = []string{FieldManagedBy, FieldIdentity, FieldCreatedTime, FieldChangedTime}
+
+// fieldConditions are the fields of resources used by conditions, for the fields which only some scans fill
+var fieldConditions = map[string]string{
+	"mgUnder":           FieldManagementGroups,
+	"mgNotUnder":        FieldManagementGroups,
+	"managedBy":         FieldManagedBy,
+	"notManaged":        FieldManagedBy,
+	"identityTypeEqual": FieldIdentity,
+	"createdBefore":     FieldCreatedTime,
+	"createdAfter":      FieldCreatedTime,
+	"olderThan":         FieldCreatedTime,
+	"changedBefore":     FieldChangedTime,
+	"changedAfter":      FieldChangedTime,
+}
+
+// CheckFields returns an error listing the conditions of ruleDef which use fields of resources that are not in
+// fields, the optional fields filled by the scan. Such conditions can't tell the resources apart
+func CheckFields(ruleDef rules.TagRules, fields []string) error {
+	var errs rules.Errors
+	for i, rule := range ruleDef.Rules {
+		for j, cond := range rule.Conditions {
+			if field, ok := fieldConditions[cond.GetType()]; ok && !contains(fields, field) {
+				errs = append(errs, errors.Errorf("rule %d (%q): condition %d (%s) needs the %s of resources, which the scan doesn't provide",
+					i, rule.Name, j, cond.GetType(), field))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// streamBuffer is the number of scanned resources waiting to be processed before scanners wait
+const streamBuffer = 64
+
+// send sends res to out, unless ctx is done first
+func send(ctx context.Context, out chan<- Resource, res Resource) error {
+	select {
+	case out <- res:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// collect returns all the resources streamed by s
+func collect(ctx context.Context, s ResourceStreamer) ([]Resource, error) {
+	out := make(chan Resource, streamBuffer)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.StreamResources(ctx, out)
+		close(out)
+	}()
+
+	tab := make([]Resource, 0)
+	for res := range out {
+		tab = append(tab, res)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return tab, nil
 }
 
 // String converts string v to the string pointer
@@ -33,7 +151,7 @@ func String(v string) *string {
 
 // GetResourceGroupTags returns a map of key value tags of a reource group rg
 func (r ResourceGroupScanner) GetResourceGroupTags(rg string) (map[string]*string, error) {
-	result, err := r.GroupsClient.Get(context.Background(), rg)
+	result, err := r.GroupsClient.Get(r.requestContext(context.Background()), rg)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GetResourceGroupTags(rg=%s): Get() failed", rg)
 	}
@@ -52,88 +170,415 @@ func NewResourceGroupScanner(s *session.AzureSession) *ResourceGroupScanner {
 		Session:         s,
 		ResourcesClient: &resClient,
 		GroupsClient:    &grClient,
+		Concurrency:     DefaultConcurrency,
 	}
 
 	return scanner
 }
 
 // ScanResourceGroup returns a list of resources and their tags from a resource group rg
-func (r ResourceGroupScanner) ScanResourceGroup(rg string) []Resource {
-	tab := make([]Resource, 0)
-	for list, err := r.ResourcesClient.ListByResourceGroupComplete(context.Background(), rg, "", "", nil); list.NotDone(); err = list.NextWithContext(context.Background()) {
-		if err != nil {
-			log.Fatal(err)
-		}
-		resource := list.Value()
-		tab = append(tab, Resource{
-			Platform:      "azure",
-			ID:            *resource.ID,
-			Name:          resource.Name,
-			Region:        *resource.Location,
-			Tags:          resource.Tags,
-			ResourceGroup: String(rg),
-		})
+func (r ResourceGroupScanner) ScanResourceGroup(rg string) ([]Resource, error) {
+	tab, err := r.listResourceGroup(rg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't scan resource group %s", rg)
 	}
-	return tab
+	return tab, nil
 }
 
-// GetResources retruns list of resources in resource group
+// GetResources retruns list of resources in resource group. Resource groups are scanned by Concurrency workers,
+// and their failures are returned together, or only logged in Partial mode
 func (r ResourceGroupScanner) GetResources() ([]Resource, error) {
-	var wg sync.WaitGroup
+	return collect(context.Background(), r)
+}
 
+// StreamResources sends the resources of the resource groups to out as they are scanned by Concurrency workers.
+// Failures of resource groups are returned together, or only logged in Partial mode
+func (r ResourceGroupScanner) StreamResources(ctx context.Context, out chan<- Resource) error {
 	groups, err := r.GetGroups()
 	if err != nil {
-		return nil, errors.Wrap(err, "GetResources(): GetGroups() failed")
+		return errors.Wrap(err, "GetResources(): GetGroups() failed")
 	}
 
-	tab := make([]Resource, 0)
-	out := make(chan []Resource)
-	for _, rg := range groups {
+	jobs := make(chan string)
+	results := make(chan error)
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency(); i++ {
 		wg.Add(1)
-		go func(rg string) {
+		go func() {
 			defer wg.Done()
-			out <- r.ScanResourceGroup(rg)
-		}(rg)
+			for rg := range jobs {
+				err := r.streamResourceGroup(ctx, rg, func(res Resource) error {
+					return send(ctx, out, res)
+				})
+				results <- errors.Wrapf(err, "can't scan resource group %s", rg)
+			}
+		}()
 	}
 	go func() {
+		for _, rg := range groups {
+			select {
+			case jobs <- rg:
+			case <-ctx.Done():
+			}
+		}
+		close(jobs)
 		wg.Wait()
-		close(out)
+		close(results)
 	}()
-	for s := range out {
-		tab = append(tab, s...)
+
+	var errs ScanErrors
+	for err := range results {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(errs) > 0 {
+		if !r.Partial {
+			return errs
+		}
+		log.Warnf("Skipped %d of %d resource groups of subscription %s: %s", len(errs), len(groups), r.Session.SubscriptionID, errs)
 	}
+	return nil
+}
 
-	return tab, nil
+// SetRetries sets the number of retries of throttled and failed requests, 0 for none, and the delay
+// before the first retry, doubled before each next one. Retry-After headers of responses take precedence
+func (r *ResourceGroupScanner) SetRetries(retries int, backoff time.Duration) {
+	r.ResourcesClient.RetryAttempts, r.ResourcesClient.RetryDuration = retries, backoff
+	r.GroupsClient.RetryAttempts, r.GroupsClient.RetryDuration = retries, backoff
+}
+
+// requestContext returns ctx for the requests of the scanner. The senders of the SDK don't send anything when
+// RetryAttempts is 0, so requests without retries get their own sender decorators
+func (r ResourceGroupScanner) requestContext(ctx context.Context) context.Context {
+	if r.ResourcesClient.RetryAttempts > 0 {
+		return ctx
+	}
+	noRetry := func(s autorest.Sender) autorest.Sender { return s }
+	return autorest.WithSendDecorators(ctx, []autorest.SendDecorator{noRetry})
+}
+
+// concurrency returns the number of resource groups scanned at the same time
+func (r ResourceGroupScanner) concurrency() int {
+	if r.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return r.Concurrency
 }
 
 // GetGroups returns list of resource groups in a subscription
 func (r ResourceGroupScanner) GetGroups() ([]string, error) {
-	tab := make([]string, 0)
-	for list, err := r.GroupsClient.ListComplete(context.Background(), "", nil); list.NotDone(); err = list.NextWithContext(context.Background()) {
-		if err != nil {
-			return nil, errors.Wrap(err, "GetGroups(): GroupsClient.ListComplete failed")
-		}
+	groups, err := r.ListGroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetGroups() failed")
+	}
+	tab := make([]string, len(groups))
+	for i, group := range groups {
+		tab[i] = group.Name
+	}
+	return tab, nil
+}
+
+// ListGroups returns the resource groups in the subscription with their tags
+func (r ResourceGroupScanner) ListGroups() ([]ResourceGroup, error) {
+	tab := make([]ResourceGroup, 0)
+	ctx := r.requestContext(context.Background())
+	list, err := r.GroupsClient.ListComplete(ctx, "", nil)
+	for ; err == nil && list.NotDone(); err = list.NextWithContext(ctx) {
 		rgName := *list.Value().Name
-		tab = append(tab, rgName)
+		if !r.Scope.IncludesResourceGroup(rgName) {
+			log.Infof("Resource group %s is out of scope", rgName)
+			continue
+		}
+		tab = append(tab, ResourceGroup{Subscription: r.Session.SubscriptionID, Name: rgName, Tags: list.Value().Tags})
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ListGroups(): GroupsClient.ListComplete failed")
 	}
 	return tab, nil
 }
 
+// Version returns the version of the SDK and of the API the scanner lists resources with
+func (r ResourceGroupScanner) Version() string {
+	return resources.UserAgent()
+}
+
+// Fields returns the optional fields of resources filled by the scanner
+func (r ResourceGroupScanner) Fields() []string {
+	fields := append([]string{}, armFields...)
+	if len(r.ManagementGroups) > 0 {
+		fields = append(fields, FieldManagementGroups)
+	}
+	return fields
+}
+
 // GetResourcesByResourceGroup returns resources in a resource group rg
 func (r ResourceGroupScanner) GetResourcesByResourceGroup(rg string) ([]Resource, error) {
+	if !r.Scope.IncludesResourceGroup(rg) {
+		return nil, errors.Errorf("resource group %s is out of scope", rg)
+	}
+	tab, err := r.ScanResourceGroup(rg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetResourcesByResourceGroup(rg=%q) failed", rg)
+	}
+	return tab, nil
+}
+
+// resourceExpand are the properties of the resources listed in addition to the default ones
+const resourceExpand = "createdTime,changedTime,provisioningState"
+
+// expandedResource is a listed resource with the expanded properties, which GenericResource of the API version
+// of the SDK doesn't have
+type expandedResource struct {
+	resources.GenericResource
+	CreatedTime       *date.Time `json:"createdTime,omitempty"`
+	ChangedTime       *date.Time `json:"changedTime,omitempty"`
+	ProvisioningState *string    `json:"provisioningState,omitempty"`
+}
+
+// expandedListResult is a page of expanded resources
+type expandedListResult struct {
+	Value    []expandedResource `json:"value"`
+	NextLink *string            `json:"nextLink,omitempty"`
+}
+
+// listResourceGroup returns the resources in the scope of the resource group rg
+func (r ResourceGroupScanner) listResourceGroup(rg string) ([]Resource, error) {
 	tab := make([]Resource, 0)
-	for list, err := r.ResourcesClient.ListByResourceGroupComplete(context.Background(), rg, "", "", nil); list.NotDone(); err = list.NextWithContext(context.Background()) {
+	err := r.streamResourceGroup(context.Background(), rg, func(res Resource) error {
+		tab = append(tab, res)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tab, nil
+}
+
+// streamResourceGroup calls emit with each resource in the scope of the resource group rg, page by page. The pages
+// are requested and decoded here, as the iterator of the SDK drops the expanded properties
+func (r ResourceGroupScanner) streamResourceGroup(ctx context.Context, rg string, emit func(Resource) error) error {
+	ctx = r.requestContext(ctx)
+	req, err := r.ResourcesClient.ListByResourceGroupPreparer(ctx, rg, "", resourceExpand, nil)
+	if err != nil {
+		return errors.Wrap(err, "ListByResourceGroupPreparer() failed")
+	}
+
+	for {
+		resp, err := r.ResourcesClient.ListByResourceGroupSender(req)
+		if err != nil {
+			return autorest.NewErrorWithError(err, "resources.Client", "ListByResourceGroup", resp, "Failure sending request")
+		}
+		var page expandedListResult
+		err = autorest.Respond(resp,
+			r.ResourcesClient.ByInspecting(),
+			autorest.WithErrorUnlessStatusCode(http.StatusOK),
+			autorest.ByUnmarshallingJSON(&page),
+			autorest.ByClosing())
 		if err != nil {
-			return nil, errors.Wrapf(err, "GetResourcesByResourceGroup(rg=%q): ListByResourceGroupComplete() failed", rg)
+			return autorest.NewErrorWithError(err, "resources.Client", "ListByResourceGroup", resp, "Failure responding to request")
 		}
-		resource := list.Value()
-		tab = append(tab, Resource{
-			Platform: "azure",
-			ID:       *resource.ID,
-			Name:     resource.Name,
-			Region:   *resource.Location,
-			Tags:     resource.Tags,
-		})
+
+		for _, resource := range page.Value {
+			if !r.Scope.IncludesResource(stringValue(resource.Type), stringValue(resource.Location)) {
+				continue
+			}
+			if err := emit(r.newResource(rg, resource)); err != nil {
+				return err
+			}
+		}
+
+		if page.NextLink == nil || *page.NextLink == "" {
+			return nil
+		}
+		req, err = autorest.Prepare((&http.Request{}).WithContext(ctx), autorest.AsGet(), autorest.WithBaseURL(*page.NextLink))
+		if err != nil {
+			return errors.Wrap(err, "can't prepare the request of the next page")
+		}
+	}
+}
+
+// newResource converts a resource listed in the resource group rg
+func (r ResourceGroupScanner) newResource(rg string, resource expandedResource) Resource {
+	res := Resource{
+		Platform:          "azure",
+		ID:                stringValue(resource.ID),
+		Name:              resource.Name,
+		Region:            stringValue(resource.Location),
+		Kind:              resource.Kind,
+		Type:              resource.Type,
+		Tags:              resource.Tags,
+		ResourceGroup:     String(rg),
+		Subscription:      r.Session.SubscriptionID,
+		ManagementGroups:  r.ManagementGroups,
+		ManagedBy:         stringValue(resource.ManagedBy),
+		ProvisioningState: stringValue(resource.ProvisioningState),
+	}
+	if properties, ok := resource.Properties.(map[string]interface{}); ok && res.ProvisioningState == "" {
+		res.ProvisioningState, _ = properties["provisioningState"].(string)
+	}
+	if resource.Sku != nil {
+		res.SKU, res.SKUTier = stringValue(resource.Sku.Name), stringValue(resource.Sku.Tier)
+	}
+	if resource.Identity != nil {
+		res.IdentityType = string(resource.Identity.Type)
+	}
+	if resource.Plan != nil {
+		res.Plan = stringValue(resource.Plan.Name)
+	}
+	if resource.CreatedTime != nil {
+		res.CreatedTime = &resource.CreatedTime.Time
+	}
+	if resource.ChangedTime != nil {
+		res.ChangedTime = &resource.ChangedTime.Time
+	}
+	return res
+}
+
+// SubscriptionsScanner scans the resources of several subscriptions
+type SubscriptionsScanner struct {
+	Scanners []*ResourceGroupScanner // one scanner for each subscription
+}
+
+// NewSubscriptionsScanner creates a SubscriptionsScanner for the subscriptions subs with Azure Session s
+func NewSubscriptionsScanner(s *session.AzureSession, subs []session.Subscription) *SubscriptionsScanner {
+	scanner := &SubscriptionsScanner{}
+	for _, sub := range subs {
+		subScanner := NewResourceGroupScanner(s.WithSubscription(sub.ID))
+		subScanner.ManagementGroups = sub.ManagementGroups
+		scanner.Scanners = append(scanner.Scanners, subScanner)
+	}
+	return scanner
+}
+
+// GetResources returns the resources of all the subscriptions
+func (m SubscriptionsScanner) GetResources() ([]Resource, error) {
+	return collect(context.Background(), m)
+}
+
+// StreamResources sends the resources of the subscriptions to out as they are scanned, one subscription after
+// the other
+func (m SubscriptionsScanner) StreamResources(ctx context.Context, out chan<- Resource) error {
+	for _, scanner := range m.Scanners {
+		err := scanner.StreamResources(ctx, out)
+		if err != nil && scanner.Partial && ctx.Err() == nil {
+			log.Warnf("Skipped subscription %s: %s", scanner.Session.SubscriptionID, err)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "subscription %s", scanner.Session.SubscriptionID)
+		}
+	}
+	return nil
+}
+
+// GetResourcesByResourceGroup returns the resources in the resource groups named rg of all the subscriptions.
+// Subscriptions without such a resource group are skipped, and so are failing subscriptions in Partial mode
+func (m SubscriptionsScanner) GetResourcesByResourceGroup(rg string) ([]Resource, error) {
+	tab := make([]Resource, 0)
+	found := false
+	for _, scanner := range m.Scanners {
+		res, err := scanner.GetResourcesByResourceGroup(rg)
+		if IsNotFound(err) {
+			log.Infof("Resource group %s not found in subscription %s", rg, scanner.Session.SubscriptionID)
+			continue
+		}
+		if err != nil && scanner.Partial {
+			log.Warnf("Skipped subscription %s: %s", scanner.Session.SubscriptionID, err)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "subscription %s", scanner.Session.SubscriptionID)
+		}
+		found = true
+		tab = append(tab, res...)
+	}
+	if !found {
+		return nil, errors.Errorf("resource group %s not found", rg)
+	}
+	return tab, nil
+}
+
+// GetGroups returns the names of the resource groups of all the subscriptions
+func (m SubscriptionsScanner) GetGroups() ([]string, error) {
+	tab := make([]string, 0)
+	for _, scanner := range m.Scanners {
+		groups, err := scanner.GetGroups()
+		if err != nil {
+			return nil, errors.Wrapf(err, "subscription %s", scanner.Session.SubscriptionID)
+		}
+		tab = append(tab, groups...)
+	}
+	return tab, nil
+}
+
+// ListGroups returns the resource groups of all the subscriptions with their tags
+func (m SubscriptionsScanner) ListGroups() ([]ResourceGroup, error) {
+	tab := make([]ResourceGroup, 0)
+	for _, scanner := range m.Scanners {
+		groups, err := scanner.ListGroups()
+		if err != nil {
+			return nil, errors.Wrapf(err, "subscription %s", scanner.Session.SubscriptionID)
+		}
+		tab = append(tab, groups...)
 	}
 	return tab, nil
 }
+
+// Version returns the version of the SDK and of the API the scanners list resources with
+func (m SubscriptionsScanner) Version() string {
+	return resources.UserAgent()
+}
+
+// GetResourceGroupTags returns the tags of the resource group rg of the first subscription which has it
+func (m SubscriptionsScanner) GetResourceGroupTags(rg string) (map[string]*string, error) {
+	for _, scanner := range m.Scanners {
+		tags, err := scanner.GetResourceGroupTags(rg)
+		if IsNotFound(err) {
+			continue
+		}
+		return tags, err
+	}
+	return nil, errors.Errorf("resource group %s not found", rg)
+}
+
+// Fields returns the optional fields of resources filled by the scanners of all the subscriptions
+func (m SubscriptionsScanner) Fields() []string {
+	if len(m.Scanners) == 0 {
+		return nil
+	}
+	var fields []string
+	for _, field := range m.Scanners[0].Fields() {
+		filled := true
+		for _, scanner := range m.Scanners[1:] {
+			filled = filled && contains(scanner.Fields(), field)
+		}
+		if filled {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ScanErrors is a list of failures of the resource groups of a scan, reported together
+type ScanErrors []error
+
+func (e ScanErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "\t* " + err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// IsNotFound returns true if err is a 404 response of Azure
+func IsNotFound(err error) bool {
+	derr, ok := errors.Cause(err).(autorest.DetailedError)
+	return ok && derr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,209 @@
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
+)
+
+func TestResourceGroupScanner_GetResourcesByResourceGroup(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"value": [
+				{"id": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/ip", "name": "ip", "type": "Microsoft.Network/publicIPAddresses", "location": "westeurope", "properties": {"provisioningState": "Failed"}},
+				{"id": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/other", "name": "other", "type": "Microsoft.Compute/disks", "location": "northeurope"}
+			]}`)
+			return
+		}
+		assert.Equal(t, resourceExpand, req.URL.Query().Get("$expand"))
+		fmt.Fprintf(w, `{"value": [{
+			"id": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk",
+			"name": "disk", "type": "Microsoft.Compute/disks", "location": "westeurope", "kind": "",
+			"sku": {"name": "Premium_LRS", "tier": "Premium"},
+			"managedBy": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			"identity": {"type": "SystemAssigned"},
+			"plan": {"name": "plan"},
+			"tags": {"env": "prod"},
+			"createdTime": "2021-01-02T03:04:05.123Z",
+			"changedTime": "2021-02-03T04:05:06Z",
+			"provisioningState": "Succeeded"
+		}], "nextLink": "%s/next?page=2"}`, server.URL)
+	}))
+	defer server.Close()
+
+	client := resources.NewClientWithBaseURI(server.URL, "sub")
+	scanner := ResourceGroupScanner{
+		Session:         &session.AzureSession{SubscriptionID: "sub"},
+		ResourcesClient: &client,
+		Scope:           rules.Scopes{{Regions: []string{"westeurope"}}},
+	}
+	got, err := scanner.GetResourcesByResourceGroup("rg")
+	assert.Nil(t, err)
+
+	created := time.Date(2021, 1, 2, 3, 4, 5, 123000000, time.UTC)
+	changed := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	want := []Resource{
+		{
+			Platform:          "azure",
+			ID:                "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk",
+			Name:              String("disk"),
+			Region:            "westeurope",
+			Kind:              String(""),
+			Type:              String("Microsoft.Compute/disks"),
+			Tags:              map[string]*string{"env": String("prod")},
+			ResourceGroup:     String("rg"),
+			Subscription:      "sub",
+			SKU:               "Premium_LRS",
+			SKUTier:           "Premium",
+			ManagedBy:         "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			IdentityType:      "SystemAssigned",
+			Plan:              "plan",
+			ProvisioningState: "Succeeded",
+			CreatedTime:       &created,
+			ChangedTime:       &changed,
+		},
+		{
+			Platform:          "azure",
+			ID:                "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/ip",
+			Name:              String("ip"),
+			Region:            "westeurope",
+			Type:              String("Microsoft.Network/publicIPAddresses"),
+			ResourceGroup:     String("rg"),
+			Subscription:      "sub",
+			ProvisioningState: "Failed",
+		},
+	}
+	assert.Equal(t, want, got)
+
+	// conditions on the resource group, as run by check
+	tagger := &Tagger{Matched: make(map[string]Matched), Rules: rules.TagRules{Rules: []rules.Rule{
+		{Name: "in rg", Conditions: []rules.ConditionItem{{"type": "rgEqual", "resourceGroup": "rg"}}},
+		{Name: "not in other", Conditions: []rules.ConditionItem{{"type": "rgNotEqual", "resourceGroup": "other"}}},
+	}}}
+	tagger.InitCondMap()
+	tagger.EvaluateRules(got)
+	assert.Len(t, tagger.Matched, 2)
+	assert.Len(t, tagger.Matched[want[0].ID].TagRules, 2)
+
+	scanner.Scope = rules.Scopes{{ExcludeResourceGroups: []string{"r?"}}}
+	_, err = scanner.GetResourcesByResourceGroup("rg")
+	assert.NotNil(t, err)
+}
+
+func TestResourceGroupScanner_GetResources(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.ToLower(req.URL.Path)
+		if strings.HasSuffix(path, "/resourcegroups") {
+			fmt.Fprint(w, `{"value": [{"name": "ok"}, {"name": "throttled"}, {"name": "broken"}]}`)
+			return
+		}
+		rg := strings.Split(path, "/")[4]
+		mu.Lock()
+		calls[rg]++
+		n := calls[rg]
+		mu.Unlock()
+		switch {
+		case rg == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error": {"code": "InternalServerError"}}`)
+		case rg == "throttled" && n == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"code": "TooManyRequests"}}`)
+		default:
+			fmt.Fprintf(w, `{"value": [{"id": "/subscriptions/sub/resourceGroups/%s/providers/x/y/res", "name": "res", "location": "westeurope"}]}`, rg)
+		}
+	}))
+	defer server.Close()
+
+	resClient := resources.NewClientWithBaseURI(server.URL, "sub")
+	grClient := resources.NewGroupsClientWithBaseURI(server.URL, "sub")
+	scanner := ResourceGroupScanner{
+		Session:         &session.AzureSession{SubscriptionID: "sub"},
+		ResourcesClient: &resClient,
+		GroupsClient:    &grClient,
+		Concurrency:     2,
+	}
+	scanner.SetRetries(2, time.Millisecond)
+
+	_, err := scanner.GetResources()
+	assert.IsType(t, ScanErrors{}, err)
+	assert.Contains(t, err.Error(), "can't scan resource group broken")
+
+	scanner.Partial = true
+	res, err := scanner.GetResources()
+	assert.Nil(t, err)
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.ID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"/subscriptions/sub/resourceGroups/ok/providers/x/y/res", "/subscriptions/sub/resourceGroups/throttled/providers/x/y/res"}, ids)
+	assert.Equal(t, 6, calls["broken"], "server errors are retried")
+	assert.Equal(t, 3, calls["throttled"], "throttling is retried")
+
+	scanner.SetRetries(0, time.Millisecond)
+	_, err = scanner.GetResourcesByResourceGroup("broken")
+	assert.NotNil(t, err)
+	assert.Equal(t, 7, calls["broken"], "retries can be turned off")
+}
+
+func TestSubscriptionsScanner_GetResourcesByResourceGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Split(req.URL.Path, "/")[2] == "down" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error": {"code": "InternalServerError"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"value": [{"id": "/subscriptions/up/resourceGroups/rg/providers/x/y/res", "name": "res", "location": "westeurope"}]}`)
+	}))
+	defer server.Close()
+
+	subs := SubscriptionsScanner{}
+	for _, sub := range []string{"up", "down"} {
+		resClient := resources.NewClientWithBaseURI(server.URL, sub)
+		grClient := resources.NewGroupsClientWithBaseURI(server.URL, sub)
+		scanner := &ResourceGroupScanner{
+			Session:         &session.AzureSession{SubscriptionID: sub},
+			ResourcesClient: &resClient,
+			GroupsClient:    &grClient,
+		}
+		scanner.SetRetries(0, time.Millisecond)
+		subs.Scanners = append(subs.Scanners, scanner)
+	}
+
+	_, err := subs.GetResourcesByResourceGroup("rg")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "subscription down")
+
+	for _, scanner := range subs.Scanners {
+		scanner.Partial = true
+	}
+	res, err := subs.GetResourcesByResourceGroup("rg")
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+}
+
+func TestCheckFields(t *testing.T) {
+	ruleDef := rules.TagRules{Rules: []rules.Rule{
+		{Name: "corp", Conditions: []rules.ConditionItem{{"type": "tagEqual", "tag": "env", "value": "prod"}, {"type": "mgNotUnder", "managementGroup": "corp"}}},
+	}}
+	assert.Nil(t, CheckFields(ruleDef, []string{FieldManagementGroups}))
+	assert.EqualError(t, CheckFields(ruleDef, nil), `rule 0 ("corp"): condition 1 (mgNotUnder) needs the managementGroups of resources, which the scan doesn't provide`)
+}
@@ -0,0 +1,182 @@
+package azure
+
+import (
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+// Limits of scripts. Memory is not limited: Starlark has no allocation hook, and a single step, like "a" * n, can
+// allocate up to the 1 GiB Starlark refuses to exceed for one string or list
+const (
+	scriptMaxSteps = 1000000         // Starlark execution steps of one call
+	scriptTimeout  = 5 * time.Second // wall time of one call
+	scriptFunction = "tags"          // function called if the action does not name one
+)
+
+// TagChanges are changes of the tags of a resource
+type TagChanges struct {
+	Set    map[string]string // tags to set, existing values are overwritten
+	Delete []string          // keys of tags to delete
+}
+
+// script is a loaded Starlark script and the function computing tag changes
+type script struct {
+	name string
+	fn   starlark.Callable
+}
+
+var scriptCache sync.Map // loaded scripts by function and source
+
+// scriptSpec describes actions running a Starlark function
+var scriptSpec = rules.Spec{
+	Type:        "script",
+	Description: "Sets and deletes the tags returned by a Starlark function of the resource",
+	Params: []rules.Param{
+		{Name: "source", Type: rules.StringParam, Description: "Starlark source of the script"},
+		{Name: "file", Type: rules.FileParam, Description: "Starlark file with the script, instead of source"},
+		{Name: "function", Type: rules.StringParam, Description: "Function called with the resource, " + scriptFunction + " if not set"},
+	},
+	Validate: func(item map[string]interface{}) error {
+		_, err := loadScript(rules.ActionItem(item))
+		return err
+	},
+}
+
+// loadScript executes the script of action p and returns the function to call
+func loadScript(p rules.ActionItem) (*script, error) {
+	source, file := p.GetString("source"), p.GetString("file")
+	name := "inline"
+	switch {
+	case source != "" && file != "":
+		return nil, errors.New("only one of source and file can be set")
+	case file != "":
+		dat, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't read script")
+		}
+		source, name = string(dat), file
+	case source == "":
+		return nil, errors.New("source or file is required")
+	}
+	function := p.GetString("function")
+	if function == "" {
+		function = scriptFunction
+	}
+
+	key := function + "\x00" + source
+	if s, ok := scriptCache.Load(key); ok {
+		return s.(*script), nil
+	}
+
+	thread := newScriptThread(name)
+	globals, err := starlark.ExecFile(thread, name, source, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load script %s", name)
+	}
+	fn, ok := globals[function].(starlark.Callable)
+	if !ok {
+		return nil, errors.Errorf("script %s has no function %s", name, function)
+	}
+	globals.Freeze()
+
+	s := &script{name: name, fn: fn}
+	scriptCache.Store(key, s)
+	return s, nil
+}
+
+// newScriptThread returns a thread without load and with the step limit of scripts
+func newScriptThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Debugf("Script %s: %s", name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	return thread
+}
+
+// run calls the function of the script with data. The function returns a dict of tags to set, with None
+// as the value of tags to delete, or None if nothing changes
+func (s *script) run(data *Resource) (TagChanges, error) {
+	thread := newScriptThread(s.name)
+
+	timer := time.AfterFunc(scriptTimeout, func() { thread.Cancel("timeout") })
+	defer timer.Stop()
+
+	result, err := starlark.Call(thread, s.fn, starlark.Tuple{resourceValue(data)}, nil)
+	if err != nil {
+		return TagChanges{}, errors.Wrapf(err, "script %s failed", s.name)
+	}
+	return tagChanges(result)
+}
+
+// resourceValue returns data as a frozen Starlark struct
+func resourceValue(data *Resource) starlark.Value {
+	keys := make([]string, 0, len(data.Tags))
+	for k := range data.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := starlark.NewDict(len(data.Tags))
+	for _, k := range keys {
+		if v := data.Tags[k]; v != nil {
+			tags.SetKey(starlark.String(k), starlark.String(*v))
+		}
+	}
+
+	resource := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id":                starlark.String(data.ID),
+		"name":              starlark.String(stringValue(data.Name)),
+		"region":            starlark.String(data.Region),
+		"resourceGroup":     starlark.String(stringValue(data.ResourceGroup)),
+		"type":              starlark.String(stringValue(data.Type)),
+		"kind":              starlark.String(stringValue(data.Kind)),
+		"subscription":      starlark.String(data.SubscriptionID()),
+		"sku":               starlark.String(data.SKU),
+		"skuTier":           starlark.String(data.SKUTier),
+		"managedBy":         starlark.String(data.ManagedBy),
+		"identityType":      starlark.String(data.IdentityType),
+		"provisioningState": starlark.String(data.ProvisioningState),
+		"tags":              tags,
+	})
+	resource.Freeze()
+	return resource
+}
+
+// tagChanges converts the result of a script to TagChanges
+func tagChanges(result starlark.Value) (TagChanges, error) {
+	changes := TagChanges{Set: make(map[string]string)}
+	if result == starlark.None {
+		return changes, nil
+	}
+	dict, ok := result.(*starlark.Dict)
+	if !ok {
+		return TagChanges{}, errors.Errorf("script returned %s, expected a dict or None", result.Type())
+	}
+	for _, item := range dict.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return TagChanges{}, errors.Errorf("script returned tag key %s, expected a string", item[0])
+		}
+		if item[1] == starlark.None {
+			changes.Delete = append(changes.Delete, key)
+			continue
+		}
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			return TagChanges{}, errors.Errorf("script returned %s for tag %s, expected a string or None", item[1].Type(), key)
+		}
+		changes.Set[key] = value
+	}
+	return changes, nil
+}
@@ -0,0 +1,90 @@
+package azure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const costCenterScript = `
+def tags(resource):
+    if resource.tags.get("costcenter") == "legacy":
+        return {"costcenter": None}
+    return {"costcenter": resource.subscription[:4] + "-" + resource.resourceGroup.upper()}
+`
+
+func TestScript_Run(t *testing.T) {
+	resource := Resource{
+		ID:            "/subscriptions/abcd1234/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+		ResourceGroup: String("rg"),
+		Tags:          map[string]*string{"costcenter": String("legacy")},
+	}
+
+	s, err := loadScript(rules.ActionItem{"type": "script", "source": costCenterScript})
+	assert.Nil(t, err)
+	changes, err := s.run(&resource)
+	assert.Nil(t, err)
+	assert.Equal(t, TagChanges{Set: map[string]string{}, Delete: []string{"costcenter"}}, changes)
+
+	resource.Tags = nil
+	changes, err = s.run(&resource)
+	assert.Nil(t, err)
+	assert.Equal(t, TagChanges{Set: map[string]string{"costcenter": "abcd-RG"}}, changes)
+}
+
+func TestScript_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  rules.ActionItem
+		loadErr bool
+	}{
+		{name: "no source", action: rules.ActionItem{"type": "script"}, loadErr: true},
+		{name: "syntax error", action: rules.ActionItem{"type": "script", "source": "def tags(:"}, loadErr: true},
+		{name: "missing function", action: rules.ActionItem{"type": "script", "source": costCenterScript, "function": "other"}, loadErr: true},
+		{name: "load is not allowed", action: rules.ActionItem{"type": "script", "source": `load("x.star", "y")`}, loadErr: true},
+		{name: "step limit", action: rules.ActionItem{"type": "script", "source": "def tags(r):\n    for i in range(100000000):\n        pass\n"}},
+		{name: "wrong result", action: rules.ActionItem{"type": "script", "source": "def tags(r):\n    return [1]\n"}},
+		{name: "wrong tag value", action: rules.ActionItem{"type": "script", "source": "def tags(r):\n    return {'a': 1}\n"}},
+		{name: "frozen resource", action: rules.ActionItem{"type": "script", "source": "def tags(r):\n    r.tags['a'] = 'b'\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := loadScript(tt.action)
+			if tt.loadErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			_, err = s.run(&Resource{ID: "1", Tags: map[string]*string{"test": String("test")}})
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestTagger_ScriptAction(t *testing.T) {
+	mockClient := new(mocks.ClientAPI)
+	mockClient.On("GetByID", context.Background(), "1").Return(resources.GenericResource{ID: String("1"), Tags: map[string]*string{"test": String("test"), "old": String("x")}}, nil)
+	mockClient.On("UpdateByID", context.Background(), "1", resources.GenericResource{Tags: map[string]*string{"test": String("test"), "owner": String("name")}}).Return(resources.UpdateByIDFuture{}, nil)
+
+	tagger := Tagger{
+		ResourcesClient: mockClient,
+		Rules: rules.TagRules{Rules: []rules.Rule{
+			{Name: "script", Conditions: []rules.ConditionItem{{"type": "tagExists", "tag": "test"}}, Actions: []rules.ActionItem{
+				{"type": "script", "source": "def owner(r):\n    return {'owner': r.name, 'old': None}\n", "function": "owner"},
+			}},
+		}},
+		Matched: make(map[string]Matched),
+	}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	tagger.EvaluateRules(testResources)
+	ael, err := tagger.ExecuteActions()
+	assert.Nil(t, err)
+	assert.Len(t, ael, 1)
+	mockClient.AssertExpectations(t)
+}
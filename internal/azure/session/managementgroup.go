@@ -0,0 +1,151 @@
+package session
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/pkg/errors"
+)
+
+// managementGroupsAPIVersion is the version of the management groups API
+const managementGroupsAPIVersion = "2020-05-01"
+
+const (
+	managementGroupType = "Microsoft.Management/managementGroups"
+	subscriptionType    = "/subscriptions"
+)
+
+type managementGroup struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Details struct {
+			Parent *struct {
+				Name string `json:"name"`
+			} `json:"parent"`
+		} `json:"details"`
+	} `json:"properties"`
+}
+
+type descendant struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Properties struct {
+		DisplayName string `json:"displayName"`
+		Parent      *struct {
+			ID string `json:"id"`
+		} `json:"parent"`
+	} `json:"properties"`
+}
+
+type descendantList struct {
+	Value    []descendant `json:"value"`
+	NextLink string       `json:"nextLink"`
+}
+
+// ManagementGroupSubscriptions returns the lowercase IDs of the subscriptions under the management group, at any depth, with the
+// names of the management groups above them, from the root of the hierarchy
+func (s *AzureSession) ManagementGroupSubscriptions(group string) (map[string][]string, error) {
+	return managementGroupSubscriptions(s.Authorizer, azure.PublicCloud.ResourceManagerEndpoint, http.DefaultClient, group)
+}
+
+func managementGroupSubscriptions(authorizer autorest.Authorizer, baseURI string, sender autorest.Sender, group string) (map[string][]string, error) {
+	ancestors, err := managementGroupPath(authorizer, baseURI, sender, group)
+	if err != nil {
+		return nil, err
+	}
+
+	var descendants []descendant
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(baseURI),
+		autorest.WithPathParameters("/providers/Microsoft.Management/managementGroups/{group}/descendants", map[string]interface{}{
+			"group": autorest.Encode("path", group),
+		}),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": managementGroupsAPIVersion}),
+		authorizer.WithAuthorization())
+	for err == nil {
+		var page descendantList
+		if err = get(sender, req, &page); err != nil {
+			break
+		}
+		descendants = append(descendants, page.Value...)
+		if page.NextLink == "" {
+			break
+		}
+		req, err = autorest.Prepare(&http.Request{}, autorest.AsGet(), autorest.WithBaseURL(page.NextLink), authorizer.WithAuthorization())
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list the descendants of management group %s", group)
+	}
+
+	parents := make(map[string]string)
+	for _, d := range descendants {
+		if d.Type == managementGroupType && d.Properties.Parent != nil {
+			parents[d.Name] = path.Base(d.Properties.Parent.ID)
+		}
+	}
+	subs := make(map[string][]string)
+	for _, d := range descendants {
+		if d.Type != subscriptionType || d.Properties.Parent == nil {
+			continue
+		}
+		// groups between the subscription and the management group, the deepest first. The length
+		// of the list is bounded in case of inconsistent parents
+		var below []string
+		for name := path.Base(d.Properties.Parent.ID); name != group && name != "" && len(below) <= len(parents); name = parents[name] {
+			below = append(below, name)
+		}
+		groups := append([]string{}, ancestors...)
+		for i := len(below) - 1; i >= 0; i-- {
+			groups = append(groups, below[i])
+		}
+		subs[strings.ToLower(d.Name)] = groups
+	}
+	return subs, nil
+}
+
+// managementGroupPath returns the names of the management groups from the root of the hierarchy to group
+func managementGroupPath(authorizer autorest.Authorizer, baseURI string, sender autorest.Sender, group string) ([]string, error) {
+	var groups []string
+	seen := make(map[string]bool)
+	for name := group; name != ""; {
+		req, err := autorest.Prepare(&http.Request{},
+			autorest.AsGet(),
+			autorest.WithBaseURL(baseURI),
+			autorest.WithPathParameters("/providers/Microsoft.Management/managementGroups/{group}", map[string]interface{}{
+				"group": autorest.Encode("path", name),
+			}),
+			autorest.WithQueryParameters(map[string]interface{}{"api-version": managementGroupsAPIVersion}),
+			authorizer.WithAuthorization())
+		var mg managementGroup
+		if err == nil {
+			err = get(sender, req, &mg)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get management group %s", name)
+		}
+		groups = append([]string{mg.Name}, groups...)
+		seen[strings.ToLower(mg.Name)] = true
+
+		name = ""
+		if parent := mg.Properties.Details.Parent; parent != nil && !seen[strings.ToLower(parent.Name)] {
+			name = parent.Name
+		}
+	}
+	return groups, nil
+}
+
+// get sends req and unmarshals the JSON response into v
+func get(sender autorest.Sender, req *http.Request, v interface{}) error {
+	resp, err := autorest.SendWithSender(sender, req)
+	if err != nil {
+		return err
+	}
+	return autorest.Respond(resp,
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(v),
+		autorest.ByClosing())
+}
@@ -0,0 +1,51 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagementGroupSubscriptions(t *testing.T) {
+	const prefix = "/providers/Microsoft.Management/managementGroups/"
+	responses := map[string]string{
+		prefix + "root": `{"name": "root", "properties": {"details": {}}}`,
+		prefix + "corp": `{"name": "corp", "properties": {"details": {"parent": {"name": "root"}}}}`,
+		prefix + "corp/descendants": `{"value": [
+			{"name": "lz", "type": "Microsoft.Management/managementGroups", "properties": {"parent": {"id": "/providers/Microsoft.Management/managementGroups/corp"}}},
+			{"name": "online", "type": "Microsoft.Management/managementGroups", "properties": {"parent": {"id": "/providers/Microsoft.Management/managementGroups/lz"}}},
+			{"name": "SUB-1", "type": "/subscriptions", "properties": {"parent": {"id": "/providers/Microsoft.Management/managementGroups/online"}}}
+		], "nextLink": "http://HOST/page2?api-version=2020-05-01"}`,
+		"/page2": `{"value": [
+			{"name": "sub-2", "type": "/subscriptions", "properties": {"parent": {"id": "/providers/Microsoft.Management/managementGroups/corp"}}}
+		]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, managementGroupsAPIVersion, r.URL.Query().Get("api-version"))
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, strings.Replace(resp, "HOST", r.Host, 1))
+	}))
+	defer server.Close()
+
+	subs, err := managementGroupSubscriptions(autorest.NullAuthorizer{}, server.URL, server.Client(), "corp")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"sub-1": {"root", "corp", "lz", "online"},
+		"sub-2": {"root", "corp"},
+	}, subs)
+
+	assert.Equal(t, []Subscription{{ID: "Sub-1", ManagementGroups: []string{"root", "corp", "lz", "online"}}},
+		underManagementGroup([]Subscription{{ID: "Sub-1"}, {ID: "sub-3"}}, subs))
+
+	_, err = managementGroupSubscriptions(autorest.NullAuthorizer{}, server.URL, server.Client(), "missing")
+	assert.NotNil(t, err)
+}
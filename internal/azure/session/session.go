@@ -24,6 +24,9 @@ type AzureSession struct {
 // 	return &contents, nil
 // }
 
+// storageResource is the resource of tokens for Azure Storage
+const storageResource = "https://storage.azure.com/"
+
 // NewFromFile creates new session from file kept in AZURE_AUTH_LOCATION.
 func NewFromFile() (*AzureSession, error) {
 	authorizer, err := auth.NewAuthorizerFromFile(azure.PublicCloud.ResourceManagerEndpoint)
@@ -39,3 +42,12 @@ func NewFromFile() (*AzureSession, error) {
 
 	return &sess, err
 }
+
+// NewStorageAuthorizerFromFile creates an authorizer for Azure Storage from file kept in AZURE_AUTH_LOCATION.
+func NewStorageAuthorizerFromFile() (autorest.Authorizer, error) {
+	authorizer, err := auth.NewAuthorizerFromFileWithResource(storageResource)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get storage authorizer")
+	}
+	return authorizer, nil
+}
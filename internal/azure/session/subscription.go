@@ -0,0 +1,190 @@
+package session
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/pkg/errors"
+)
+
+// subscriptionsAPIVersion is the first version of the subscriptions API returning their tags
+const subscriptionsAPIVersion = "2020-01-01"
+
+// Subscription is an Azure subscription the session has access to
+type Subscription struct {
+	ID    string            `json:"subscriptionId"`
+	Name  string            `json:"displayName"`
+	State string            `json:"state"`
+	Tags  map[string]string `json:"tags"`
+
+	ManagementGroups []string `json:"-"` // names of the management groups above the subscription, from the root, if known
+}
+
+// String returns the name and the ID of the subscription
+func (s Subscription) String() string {
+	if s.Name == "" {
+		return s.ID
+	}
+	return s.Name + " (" + s.ID + ")"
+}
+
+// Enabled returns true if resources of the subscription can be read and updated
+func (s Subscription) Enabled() bool {
+	return s.State == "" || s.State == "Enabled" || s.State == "Warned" || s.State == "PastDue"
+}
+
+type subscriptionList struct {
+	Value    []Subscription `json:"value"`
+	NextLink string         `json:"nextLink"`
+}
+
+// WithSubscription returns a copy of the session for the subscription id
+func (s *AzureSession) WithSubscription(id string) *AzureSession {
+	sess := *s
+	sess.SubscriptionID = id
+	return &sess
+}
+
+// ListSubscriptions returns the subscriptions the session has access to, sorted by name
+func (s *AzureSession) ListSubscriptions() ([]Subscription, error) {
+	return listSubscriptions(s.Authorizer, azure.PublicCloud.ResourceManagerEndpoint, http.DefaultClient)
+}
+
+func listSubscriptions(authorizer autorest.Authorizer, baseURI string, sender autorest.Sender) ([]Subscription, error) {
+	var subs []Subscription
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(baseURI),
+		autorest.WithPath("/subscriptions"),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": subscriptionsAPIVersion}),
+		authorizer.WithAuthorization())
+	for err == nil {
+		var page subscriptionList
+		if err = get(sender, req, &page); err != nil {
+			break
+		}
+		subs = append(subs, page.Value...)
+		if page.NextLink == "" {
+			sort.Slice(subs, func(i, j int) bool { return subs[i].Name < subs[j].Name })
+			return subs, nil
+		}
+		req, err = autorest.Prepare(&http.Request{}, autorest.AsGet(), autorest.WithBaseURL(page.NextLink), authorizer.WithAuthorization())
+	}
+	return nil, errors.Wrap(err, "can't list subscriptions")
+}
+
+// SubscriptionFilter selects subscriptions
+type SubscriptionFilter struct {
+	All   bool              // all the enabled subscriptions
+	IDs   []string          // IDs or names of subscriptions
+	Names []string          // patterns (path.Match) of the names of subscriptions
+	Tags  map[string]string // tags subscriptions must have, all of them
+
+	ManagementGroup string // only subscriptions under the management group, at any depth, see SelectSubscriptions
+}
+
+// IsZero returns true if the filter selects nothing, in which case the subscription of the session is used
+func (f SubscriptionFilter) IsZero() bool {
+	return !f.All && len(f.IDs) == 0 && len(f.Names) == 0 && len(f.Tags) == 0 && f.ManagementGroup == ""
+}
+
+// SelectSubscriptions lists the subscriptions the session has access to and returns the ones selected by f
+func (s *AzureSession) SelectSubscriptions(f SubscriptionFilter) ([]Subscription, error) {
+	subs, err := s.ListSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	if f.ManagementGroup != "" {
+		groups, err := s.ManagementGroupSubscriptions(f.ManagementGroup)
+		if err != nil {
+			return nil, err
+		}
+		subs = underManagementGroup(subs, groups)
+	}
+	return f.Select(subs)
+}
+
+// underManagementGroup returns the subscriptions of subs which are in groups, as returned by ManagementGroupSubscriptions,
+// with their management groups
+func underManagementGroup(subs []Subscription, groups map[string][]string) []Subscription {
+	var under []Subscription
+	for _, sub := range subs {
+		if path, ok := groups[strings.ToLower(sub.ID)]; ok {
+			sub.ManagementGroups = path
+			under = append(under, sub)
+		}
+	}
+	return under
+}
+
+// Select returns the subscriptions of subs selected by the filter, ignoring ManagementGroup. Subscriptions given by ID or name must exist,
+// and are only filtered out by Names and Tags. Without IDs, the enabled subscriptions matching Names and Tags are selected
+func (f SubscriptionFilter) Select(subs []Subscription) ([]Subscription, error) {
+	for _, pattern := range f.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid subscription name pattern %q", pattern)
+		}
+	}
+
+	candidates := subs
+	if len(f.IDs) > 0 && !f.All {
+		candidates = nil
+		for _, id := range f.IDs {
+			sub, ok := findSubscription(subs, id)
+			if !ok {
+				return nil, errors.Errorf("subscription %q not found", id)
+			}
+			if !sub.Enabled() {
+				return nil, errors.Errorf("subscription %s is %s", sub, sub.State)
+			}
+			candidates = append(candidates, sub)
+		}
+	}
+
+	var selected []Subscription
+	seen := make(map[string]bool)
+	for _, sub := range candidates {
+		if seen[sub.ID] || !sub.Enabled() || !f.matches(sub) {
+			continue
+		}
+		seen[sub.ID] = true
+		selected = append(selected, sub)
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no subscription selected")
+	}
+	return selected, nil
+}
+
+func (f SubscriptionFilter) matches(sub Subscription) bool {
+	if len(f.Names) > 0 {
+		matched := false
+		for _, pattern := range f.Names {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(sub.Name)); ok {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for k, v := range f.Tags {
+		if value, ok := sub.Tags[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func findSubscription(subs []Subscription, idOrName string) (Subscription, bool) {
+	for _, sub := range subs {
+		if strings.EqualFold(sub.ID, idOrName) || strings.EqualFold(sub.Name, idOrName) {
+			return sub, true
+		}
+	}
+	return Subscription{}, false
+}
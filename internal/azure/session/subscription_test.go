@@ -0,0 +1,78 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSubscriptions = []Subscription{
+	{ID: "1", Name: "dev-app", State: "Enabled", Tags: map[string]string{"env": "dev"}},
+	{ID: "2", Name: "prod-app", State: "Enabled", Tags: map[string]string{"env": "prod", "team": "app"}},
+	{ID: "3", Name: "prod-data", State: "Enabled", Tags: map[string]string{"env": "prod", "team": "data"}},
+	{ID: "4", Name: "prod-old", State: "Disabled", Tags: map[string]string{"env": "prod"}},
+}
+
+func TestListSubscriptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, subscriptionsAPIVersion, r.URL.Query().Get("api-version"))
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"value": [{"subscriptionId": "1", "displayName": "dev", "state": "Enabled", "tags": {"env": "dev"}}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"value": [{"subscriptionId": "2", "displayName": "prod", "state": "Enabled"}], "nextLink": "http://%s/subscriptions?api-version=%s&page=2"}`, r.Host, subscriptionsAPIVersion)
+	}))
+	defer server.Close()
+
+	subs, err := listSubscriptions(autorest.NullAuthorizer{}, server.URL, server.Client())
+	assert.Nil(t, err)
+	assert.Equal(t, []Subscription{
+		{ID: "1", Name: "dev", State: "Enabled", Tags: map[string]string{"env": "dev"}},
+		{ID: "2", Name: "prod", State: "Enabled"},
+	}, subs)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer failing.Close()
+	_, err = listSubscriptions(autorest.NullAuthorizer{}, failing.URL, failing.Client())
+	assert.NotNil(t, err)
+}
+
+func TestSubscriptionFilter_Select(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  SubscriptionFilter
+		want    []string
+		wantErr string
+	}{
+		{"all enabled", SubscriptionFilter{All: true}, []string{"1", "2", "3"}, ""},
+		{"by id and name", SubscriptionFilter{IDs: []string{"3", "DEV-APP", "3"}}, []string{"3", "1"}, ""},
+		{"unknown", SubscriptionFilter{IDs: []string{"5"}}, nil, `subscription "5" not found`},
+		{"disabled", SubscriptionFilter{IDs: []string{"prod-old"}}, nil, "subscription prod-old (4) is Disabled"},
+		{"by name pattern", SubscriptionFilter{Names: []string{"prod-*"}}, []string{"2", "3"}, ""},
+		{"by tag", SubscriptionFilter{Tags: map[string]string{"env": "prod", "team": "data"}}, []string{"3"}, ""},
+		{"ids filtered by tag", SubscriptionFilter{IDs: []string{"1", "2"}, Tags: map[string]string{"env": "prod"}}, []string{"2"}, ""},
+		{"nothing matches", SubscriptionFilter{Names: []string{"test-*"}}, nil, "no subscription selected"},
+		{"invalid pattern", SubscriptionFilter{Names: []string{"["}}, nil, `invalid subscription name pattern "[": syntax error in pattern`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs, err := tt.filter.Select(testSubscriptions)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			var ids []string
+			for _, sub := range subs {
+				ids = append(ids, sub.ID)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
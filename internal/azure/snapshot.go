@@ -0,0 +1,203 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+// SnapshotVersion is the version of the format of snapshots
+const SnapshotVersion = 1
+
+// Snapshot is an inventory of scanned resources and resource groups, which rules can be evaluated against offline
+type Snapshot struct {
+	Metadata       SnapshotMetadata   `json:"metadata"`
+	ResourceGroups []SnapshotGroup    `json:"resourceGroups"`
+	Resources      []ResourceDocument `json:"resources"`
+}
+
+// SnapshotMetadata describes the scan that made a snapshot
+type SnapshotMetadata struct {
+	Version        int       `json:"version"`
+	CreatedAt      time.Time `json:"createdAt"`
+	ToolVersion    string    `json:"toolVersion,omitempty"`    // version of tagmanager
+	Scanner        string    `json:"scanner"`                  // kind of scanner, like arm or graph
+	ScannerVersion string    `json:"scannerVersion,omitempty"` // version of the SDK and of the API of the scanner
+	Subscriptions  []string  `json:"subscriptions"`            // IDs of the scanned subscriptions
+	Fields         []string  `json:"fields,omitempty"`         // optional fields of resources filled by the scan, see CheckFields
+}
+
+// SnapshotGroup is a resource group in a snapshot
+type SnapshotGroup struct {
+	Subscription string            `json:"subscription,omitempty"` // ID of the subscription, empty in imported inventories without it
+	Name         string            `json:"name"`
+	Tags         map[string]string `json:"tags"`
+}
+
+// NewSnapshot scans the resource groups and resources of scanner
+func NewSnapshot(scanner Scanner, metadata SnapshotMetadata) (*Snapshot, error) {
+	metadata.Version = SnapshotVersion
+	metadata.ScannerVersion = scanner.Version()
+	metadata.Fields = scanner.Fields()
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+	snapshot := &Snapshot{Metadata: metadata, ResourceGroups: make([]SnapshotGroup, 0)}
+
+	groups, err := scanner.ListGroups()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't scan resource groups")
+	}
+	for _, group := range groups {
+		snapshot.ResourceGroups = append(snapshot.ResourceGroups, SnapshotGroup{Subscription: group.Subscription, Name: group.Name, Tags: stringTags(group.Tags)})
+	}
+
+	resources, err := scanner.GetResources()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't scan resources")
+	}
+	snapshot.Resources = make([]ResourceDocument, len(resources))
+	for i := range resources {
+		snapshot.Resources[i] = NewResourceDocument(&resources[i])
+	}
+	return snapshot, nil
+}
+
+// pointerTags converts the tag values of a snapshot back to the pointers of the SDK
+func pointerTags(values map[string]string) map[string]*string {
+	tags := make(map[string]*string, len(values))
+	for k, v := range values {
+		tags[k] = String(v)
+	}
+	return tags
+}
+
+// WriteSnapshot writes the snapshot to the file named filename
+func WriteSnapshot(filename string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "can't encode snapshot")
+	}
+	return errors.Wrapf(ioutil.WriteFile(filename, data, 0600), "can't write snapshot %s", filename)
+}
+
+// ReadSnapshot reads the snapshot in the file named filename
+func ReadSnapshot(filename string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read snapshot %s", filename)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, errors.Wrapf(err, "can't decode snapshot %s", filename)
+	}
+	if snapshot.Metadata.Version > SnapshotVersion {
+		return nil, errors.Errorf("snapshot %s has version %d, this tagmanager supports up to %d", filename, snapshot.Metadata.Version, SnapshotVersion)
+	}
+	return &snapshot, nil
+}
+
+// SnapshotScanner is a Scanner of the resources of a snapshot, which doesn't call Azure
+type SnapshotScanner struct {
+	Snapshot *Snapshot
+	Scope    rules.Scopes // resource groups and resources out of the scope are never returned
+}
+
+// GetResources returns the resources of the snapshot
+func (s SnapshotScanner) GetResources() ([]Resource, error) {
+	return collect(context.Background(), s)
+}
+
+// StreamResources sends the resources of the snapshot to out
+func (s SnapshotScanner) StreamResources(ctx context.Context, out chan<- Resource) error {
+	for _, doc := range s.Snapshot.Resources {
+		if !s.includes(doc) {
+			continue
+		}
+		if err := send(ctx, out, doc.Resource()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includes returns if the resource doc is in the scope of the scanner
+func (s SnapshotScanner) includes(doc ResourceDocument) bool {
+	return s.Scope.IncludesResourceGroup(doc.ResourceGroup) && s.Scope.IncludesResource(doc.Type, doc.Region)
+}
+
+// Fields returns the optional fields of resources filled by the scan of the snapshot
+func (s SnapshotScanner) Fields() []string {
+	return s.Snapshot.Metadata.Fields
+}
+
+// Version returns the version of the scanner which made the snapshot
+func (s SnapshotScanner) Version() string {
+	return s.Snapshot.Metadata.ScannerVersion
+}
+
+// GetResourcesByResourceGroup returns the resources of the snapshot in the resource groups named rg
+func (s SnapshotScanner) GetResourcesByResourceGroup(rg string) ([]Resource, error) {
+	if !s.Scope.IncludesResourceGroup(rg) {
+		return nil, errors.Errorf("resource group %s is out of scope", rg)
+	}
+	if _, ok := s.group(rg); !ok {
+		return nil, errors.Errorf("resource group %s not found in the snapshot", rg)
+	}
+	tab := make([]Resource, 0)
+	for _, doc := range s.Snapshot.Resources {
+		if strings.EqualFold(doc.ResourceGroup, rg) && s.includes(doc) {
+			tab = append(tab, doc.Resource())
+		}
+	}
+	return tab, nil
+}
+
+// GetGroups returns the names of the resource groups of the snapshot
+func (s SnapshotScanner) GetGroups() ([]string, error) {
+	groups, err := s.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	tab := make([]string, len(groups))
+	for i, group := range groups {
+		tab[i] = group.Name
+	}
+	return tab, nil
+}
+
+// ListGroups returns the resource groups of the snapshot with their tags
+func (s SnapshotScanner) ListGroups() ([]ResourceGroup, error) {
+	tab := make([]ResourceGroup, 0, len(s.Snapshot.ResourceGroups))
+	for _, group := range s.Snapshot.ResourceGroups {
+		if s.Scope.IncludesResourceGroup(group.Name) {
+			tab = append(tab, ResourceGroup{Subscription: group.Subscription, Name: group.Name, Tags: pointerTags(group.Tags)})
+		}
+	}
+	return tab, nil
+}
+
+// GetResourceGroupTags returns the tags of the resource group rg of the snapshot
+func (s SnapshotScanner) GetResourceGroupTags(rg string) (map[string]*string, error) {
+	group, ok := s.group(rg)
+	if !ok {
+		return nil, errors.Errorf("resource group %s not found in the snapshot", rg)
+	}
+	return pointerTags(group.Tags), nil
+}
+
+// group returns the first resource group named rg of the snapshot in the scope of the scanner
+func (s SnapshotScanner) group(rg string) (SnapshotGroup, bool) {
+	for _, group := range s.Snapshot.ResourceGroups {
+		if strings.EqualFold(group.Name, rg) && s.Scope.IncludesResourceGroup(group.Name) {
+			return group, true
+		}
+	}
+	return SnapshotGroup{}, false
+}
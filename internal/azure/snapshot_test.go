@@ -0,0 +1,82 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	scanned := SnapshotScanner{Snapshot: &Snapshot{
+		ResourceGroups: []SnapshotGroup{
+			{Subscription: "a", Name: "rg1", Tags: map[string]string{"env": "prod"}},
+			{Subscription: "b", Name: "rg1", Tags: map[string]string{"env": "dev"}},
+			{Subscription: "a", Name: "rg2", Tags: map[string]string{}},
+		},
+		Resources: make([]ResourceDocument, len(testResources)),
+		Metadata:  SnapshotMetadata{ScannerVersion: "sdk/1", Fields: []string{FieldManagedBy}},
+	}}
+	for i := range testResources {
+		scanned.Snapshot.Resources[i] = NewResourceDocument(&testResources[i])
+	}
+	snapshot, err := NewSnapshot(scanned, SnapshotMetadata{Scanner: "arm", Subscriptions: []string{"sub"}})
+	assert.Nil(t, err)
+	assert.Equal(t, SnapshotVersion, snapshot.Metadata.Version)
+	assert.False(t, snapshot.Metadata.CreatedAt.IsZero())
+
+	filename := filepath.Join(dir, "inventory.json")
+	assert.Nil(t, WriteSnapshot(filename, snapshot))
+	read, err := ReadSnapshot(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "arm", read.Metadata.Scanner)
+	assert.Equal(t, []string{"sub"}, read.Metadata.Subscriptions)
+	assert.Equal(t, "sdk/1", read.Metadata.ScannerVersion, "version of the scanner")
+	assert.Equal(t, []string{FieldManagedBy}, read.Metadata.Fields, "fields of the scanner")
+	assert.Equal(t, scanned.Snapshot.ResourceGroups, read.ResourceGroups, "same-named groups of subscriptions keep their tags")
+
+	scanner := SnapshotScanner{Snapshot: read}
+	res, err := scanner.GetResources()
+	assert.Nil(t, err)
+	assert.Len(t, res, len(testResources))
+	for i := range res {
+		assert.Equal(t, scanned.Snapshot.Resources[i], NewResourceDocument(&res[i]))
+	}
+
+	groups, err := scanner.GetGroups()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rg1", "rg1", "rg2"}, groups)
+	tags, err := scanner.GetResourceGroupTags("RG1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*string{"env": String("prod")}, tags)
+
+	res, err = scanner.GetResourcesByResourceGroup("rg2")
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "3", res[0].ID)
+	_, err = scanner.GetResourcesByResourceGroup("rg3")
+	assert.EqualError(t, err, "resource group rg3 not found in the snapshot")
+
+	scanner.Scope = rules.Scopes{{ExcludeResourceGroups: []string{"rg2"}}}
+	res, err = scanner.GetResources()
+	assert.Nil(t, err)
+	assert.Len(t, res, len(testResources)-1, "resources out of the scope aren't returned")
+	groups, err = scanner.GetGroups()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rg1", "rg1"}, groups)
+	_, err = scanner.GetResourcesByResourceGroup("rg2")
+	assert.EqualError(t, err, "resource group rg2 is out of scope")
+
+	read.Metadata.Version = SnapshotVersion + 1
+	assert.Nil(t, WriteSnapshot(filename, read))
+	_, err = ReadSnapshot(filename)
+	assert.Error(t, err)
+}
@@ -3,6 +3,9 @@ package azure
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources/resourcesapi"
@@ -21,6 +24,7 @@ type Tagger struct {
 	actionMap       actionFuncMap  // map of implementation of actions
 	dryRun          bool           // if true, actions will not be executed
 	ResourcesClient resourcesapi.ClientAPI
+	now             time.Time // current time of the conditions on the age of resources, set on first use
 }
 
 // Matched represents rules that mathc for a resource
@@ -31,9 +35,25 @@ type Matched struct {
 
 //ActionExecution stores information about execution of actions of a rule
 type ActionExecution struct {
-	ResourceID string
-	RuleName   string
-	Actions    []rules.ActionItem
+	ResourceID   string
+	Subscription string // ID of the subscription of the resource
+	RuleName     string
+	Mode         string // mode of the rule, actions of audit rules are never executed
+	Actions      []rules.ActionItem
+	Rule         rules.Rule        // the rule the actions come from, with its metadata
+	Tags         map[string]string // tags of the resource when the rules were evaluated
+	Outputs      []ActionOutput
+}
+
+// ActionOutput is the output of an executed action, shown in reports
+type ActionOutput struct {
+	Type   string
+	Output string
+}
+
+// NonCompliant returns true if the execution comes from an audit rule, so the resource was only reported
+func (ae ActionExecution) NonCompliant() bool {
+	return ae.Mode == rules.ModeAudit
 }
 
 //NewTagger creates tagger
@@ -48,193 +68,615 @@ func NewTagger(ruleDef rules.TagRules, session *session.AzureSession) *Tagger {
 		ResourcesClient: &grClient,
 	}
 
+	if ruleDef.DryRun != nil && *ruleDef.DryRun {
+		tagger.dryRun = true
+	}
+
 	tagger.InitActionMap()
 	tagger.InitCondMap()
 
 	return &tagger
 }
 
-// DryRun returns true if the check should be simulated
+// DryRun sets the tagger to simulate the actions
 func (t *Tagger) DryRun() {
 	t.dryRun = true
 }
 
-// InitActionMap initializes action map with supported actions
+// IsDryRun returns true if actions are only simulated, either by DryRun() or by dryrun in the rules
+func (t *Tagger) IsDryRun() bool {
+	return t.dryRun
+}
+
+// InitActionMap initializes action map with supported actions, including the registered ones
 func (t *Tagger) InitActionMap() {
 	t.actionMap = actionFuncMap{}
-	t.actionMap["addTag"] = func(p map[string]string, data *Resource) error {
-		err := t.createOrUpdateTag(data.ID, p["tag"], p["value"])
+	t.addAction(rules.Spec{
+		Type:        "addTag",
+		Description: "Adds a tag, if the resource does not have it yet",
+		Params:      []rules.Param{tagParam, valueParam},
+	}, func(p rules.ActionItem, data *Resource) (string, error) {
+		err := t.createOrUpdateTag(data.ID, p.GetString("tag"), p.GetString("value"))
 		if err != nil {
-			return errors.Wrapf(err, "Action addTag failed for resource %s", data.ID)
+			return "", errors.Wrapf(err, "Action addTag failed for resource %s", data.ID)
 		}
 
-		return nil
-	}
+		return "", nil
+	})
 
-	t.actionMap["delTag"] = func(p map[string]string, data *Resource) error {
-		err := t.deleteTag(data.ID, p["tag"])
+	t.addAction(rules.Spec{
+		Type:        "delTag",
+		Description: "Deletes a tag",
+		Params:      []rules.Param{tagParam},
+	}, func(p rules.ActionItem, data *Resource) (string, error) {
+		err := t.deleteTag(data.ID, p.GetString("tag"))
 		if err != nil {
-			return errors.Wrapf(err, "Action delTag failed for resource %s", data.ID)
+			return "", errors.Wrapf(err, "Action delTag failed for resource %s", data.ID)
 		}
-		return nil
-	}
+		return "", nil
+	})
 
-	t.actionMap["cleanTags"] = func(p map[string]string, data *Resource) error {
+	t.addAction(rules.Spec{
+		Type:        "cleanTags",
+		Description: "Deletes all tags",
+	}, func(p rules.ActionItem, data *Resource) (string, error) {
 		err := t.deleteAllTags(data.ID)
 		if err != nil {
-			return errors.Wrapf(err, "Action cleanTags failed for resource %s", data.ID)
+			return "", errors.Wrapf(err, "Action cleanTags failed for resource %s", data.ID)
 		}
-		return nil
+		return "", nil
+	})
+
+	t.addAction(rules.Spec{
+		Type:        "addTags",
+		Description: "Adds tags the resource does not have yet",
+		Params:      []rules.Param{{Name: "tags", Type: rules.StringMapParam, Required: true, Description: "Tags to add, keys and values"}},
+	}, func(p rules.ActionItem, data *Resource) (string, error) {
+		err := t.addTags(data.ID, p.GetMap("tags"))
+		if err != nil {
+			return "", errors.Wrapf(err, "Action addTags failed for resource %s", data.ID)
+		}
+		return "", nil
+	})
+
+	t.addAction(rules.Spec{
+		Type:        "delTags",
+		Description: "Deletes tags",
+		Params:      []rules.Param{{Name: "tags", Type: rules.StringListParam, Required: true, Description: "Keys of the tags to delete"}},
+	}, func(p rules.ActionItem, data *Resource) (string, error) {
+		err := t.deleteTags(data.ID, p.GetStrings("tags"))
+		if err != nil {
+			return "", errors.Wrapf(err, "Action delTags failed for resource %s", data.ID)
+		}
+		return "", nil
+	})
+
+	t.addChangesAction(scriptSpec, func(p rules.ActionItem, data *Resource) (TagChanges, error) {
+		s, err := loadScript(p)
+		if err != nil {
+			return TagChanges{}, err
+		}
+		return s.run(data)
+	})
+
+	t.actionMap[execSpec.Type] = action{
+		spec:    execSpec,
+		execute: t.runCommand,
+		runsInDryRun: func(p rules.ActionItem) bool {
+			return p.GetString("dryRun") == "true"
+		},
+	}
+
+	t.actionMap[webhookSpec.Type] = action{
+		spec:        webhookSpec,
+		executeRule: t.callWebhook,
+		runsInDryRun: func(p rules.ActionItem) bool {
+			return p.GetString("dryRun") == "true"
+		},
 	}
 
+	t.addRegisteredActions()
 }
 
-// InitCondMap initializes conditions map with supported conditions
+// InitCondMap initializes conditions map with supported conditions, including the registered ones
 func (t *Tagger) InitCondMap() {
 	t.condMap = condFuncMap{}
-	t.condMap["noTags"] = func(p map[string]string, data *Resource) bool {
+	t.addCondition(rules.Spec{
+		Type:        "noTags",
+		Description: "The resource has no tags",
+	}, func(p rules.ConditionItem, data *Resource) bool {
 		if len(data.Tags) == 0 {
 			return true
 		}
 		return false
-	}
+	})
 
-	t.condMap["tagEqual"] = func(p map[string]string, data *Resource) bool {
+	t.addCondition(rules.Spec{
+		Type:        "tagEqual",
+		Description: "The tag has the value",
+		Params:      []rules.Param{tagParam, valueParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
 		tags := data.Tags
 		if len(tags) == 0 {
 			return false
 		}
 		for k, tag := range tags {
-			if p["tag"] == k && p["value"] == *tag {
+			if p.GetString("tag") == k && p.GetString("value") == *tag {
 				return true
 			}
 		}
 		return false
-	}
+	})
 
-	t.condMap["tagNotEqual"] = func(p map[string]string, data *Resource) bool {
+	t.addCondition(rules.Spec{
+		Type:        "tagNotEqual",
+		Description: "The tag exists with a value different than the value",
+		Params:      []rules.Param{tagParam, valueParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
 		tags := data.Tags
 		if len(tags) == 0 {
 			return false
 		}
 		for k, tag := range tags {
-			if p["tag"] == k && p["value"] != *tag {
+			if p.GetString("tag") == k && p.GetString("value") != *tag {
 				return true
 			}
 		}
 		return false
-	}
-
-	t.condMap["tagExists"] = func(p map[string]string, data *Resource) bool {
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "tagValueIn",
+		Description: "The tag has one of the values",
+		Params:      []rules.Param{tagParam, valuesParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		tag, ok := data.Tags[p.GetString("tag")]
+		return ok && tag != nil && contains(p.GetStrings("values"), *tag)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "tagValueNotIn",
+		Description: "The tag exists with a value other than the values",
+		Params:      []rules.Param{tagParam, valuesParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		tag, ok := data.Tags[p.GetString("tag")]
+		return ok && tag != nil && !contains(p.GetStrings("values"), *tag)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "tagExists",
+		Description: "The tag exists",
+		Params:      []rules.Param{tagParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
 		tags := data.Tags
 		if len(tags) == 0 {
 			return false
 		}
-		if _, ok := tags[p["tag"]]; ok {
+		if _, ok := tags[p.GetString("tag")]; ok {
 			return true
 		}
 		return false
 
-	}
+	})
 
-	t.condMap["tagNotExists"] = func(p map[string]string, data *Resource) bool {
+	t.addCondition(rules.Spec{
+		Type:        "tagNotExists",
+		Description: "The tag does not exist",
+		Params:      []rules.Param{tagParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
 		tags := data.Tags
 		if len(tags) == 0 {
 			return true
 		}
-		if _, ok := tags[p["tag"]]; !ok {
+		if _, ok := tags[p.GetString("tag")]; !ok {
 			return true
 		}
 		return false
-	}
-
-	t.condMap["regionEqual"] = func(p map[string]string, data *Resource) bool {
-		if p["region"] == data.Region {
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "regionEqual",
+		Description: "The resource is in the region",
+		Params:      []rules.Param{regionParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		if p.GetString("region") == data.Region {
 			return true
 		}
 		return false
-	}
-
-	t.condMap["regionNotEqual"] = func(p map[string]string, data *Resource) bool {
-		if p["region"] != data.Region {
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "regionNotEqual",
+		Description: "The resource is not in the region",
+		Params:      []rules.Param{regionParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		if p.GetString("region") != data.Region {
 			return true
 		}
 		return false
-	}
-
-	t.condMap["rgEqual"] = func(p map[string]string, data *Resource) bool {
-		if p["resourceGroup"] == *data.ResourceGroup {
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "regionIn",
+		Description: "The resource is in one of the regions",
+		Params:      []rules.Param{regionsParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return contains(p.GetStrings("regions"), data.Region)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "regionNotIn",
+		Description: "The resource is in none of the regions",
+		Params:      []rules.Param{regionsParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return !contains(p.GetStrings("regions"), data.Region)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "rgEqual",
+		Description: "The resource is in the resource group",
+		Params:      []rules.Param{resourceGroupParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		if p.GetString("resourceGroup") == stringValue(data.ResourceGroup) {
 			return true
 		}
 		return false
-	}
-
-	t.condMap["rgNotEqual"] = func(p map[string]string, data *Resource) bool {
-		if p["resourceGroup"] != *data.ResourceGroup {
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "rgNotEqual",
+		Description: "The resource is not in the resource group",
+		Params:      []rules.Param{resourceGroupParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		if p.GetString("resourceGroup") != stringValue(data.ResourceGroup) {
 			return true
 		}
 		return false
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "rgIn",
+		Description: "The resource is in one of the resource groups",
+		Params:      []rules.Param{resourceGroupsParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return contains(p.GetStrings("resourceGroups"), stringValue(data.ResourceGroup))
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "rgNotIn",
+		Description: "The resource is in none of the resource groups",
+		Params:      []rules.Param{resourceGroupsParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return !contains(p.GetStrings("resourceGroups"), stringValue(data.ResourceGroup))
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "mgUnder",
+		Description: "The subscription of the resource is under the management group, at any depth",
+		Params:      []rules.Param{managementGroupParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return containsFold(data.ManagementGroups, p.GetString("managementGroup"))
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "mgNotUnder",
+		Description: "The subscription of the resource is not under the management group",
+		Params:      []rules.Param{managementGroupParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return len(data.ManagementGroups) > 0 && !containsFold(data.ManagementGroups, p.GetString("managementGroup"))
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "skuEqual",
+		Description: "The SKU of the resource is the SKU, ignoring case",
+		Params:      []rules.Param{skuParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.SKU != "" && strings.EqualFold(p.GetString("sku"), data.SKU)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "skuIn",
+		Description: "The SKU of the resource is one of the SKUs, ignoring case",
+		Params:      []rules.Param{skusParam},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.SKU != "" && containsFold(p.GetStrings("skus"), data.SKU)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "skuTierEqual",
+		Description: "The tier of the SKU of the resource is the tier, ignoring case",
+		Params:      []rules.Param{{Name: "tier", Type: rules.StringParam, Required: true, Description: "Tier of the SKU, like Premium"}},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.SKUTier != "" && strings.EqualFold(p.GetString("tier"), data.SKUTier)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "managedBy",
+		Description: "The resource is managed by a resource with an ID matching the glob, like */managedClusters/*",
+		Params:      []rules.Param{{Name: "managedBy", Type: rules.StringParam, Required: true, Description: "Glob of the ID of the managing resource"}},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.ManagedBy != "" && rules.Glob(p.GetString("managedBy"), data.ManagedBy)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "notManaged",
+		Description: "The resource is not managed by another resource",
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.ManagedBy == ""
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "identityTypeEqual",
+		Description: "The managed identity of the resource is of the type, ignoring case. None matches resources without identity",
+		Params:      []rules.Param{{Name: "identityType", Type: rules.StringParam, Required: true, Description: "Type of the identity, like SystemAssigned, UserAssigned or None"}},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		identityType := data.IdentityType
+		if identityType == "" {
+			identityType = "None"
+		}
+		return strings.EqualFold(p.GetString("identityType"), identityType)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "planEqual",
+		Description: "The marketplace plan of the resource is the plan, ignoring case",
+		Params:      []rules.Param{{Name: "plan", Type: rules.StringParam, Required: true, Description: "Name of the plan"}},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.Plan != "" && strings.EqualFold(p.GetString("plan"), data.Plan)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "provisioningStateEqual",
+		Description: "The provisioning state of the resource is the state, ignoring case",
+		Params:      []rules.Param{{Name: "state", Type: rules.StringParam, Required: true, Description: "Provisioning state, like Succeeded or Failed"}},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.ProvisioningState != "" && strings.EqualFold(p.GetString("state"), data.ProvisioningState)
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "createdBefore",
+		Description: "The resource was created before the time",
+		Params:      []rules.Param{timeParam},
+		Validate:    validateTime,
+	}, t.timeCondition(createdTime, true))
+
+	t.addCondition(rules.Spec{
+		Type:        "createdAfter",
+		Description: "The resource was created after the time",
+		Params:      []rules.Param{timeParam},
+		Validate:    validateTime,
+	}, t.timeCondition(createdTime, false))
+
+	t.addCondition(rules.Spec{
+		Type:        "changedBefore",
+		Description: "The resource was last changed before the time",
+		Params:      []rules.Param{timeParam},
+		Validate:    validateTime,
+	}, t.timeCondition(changedTime, true))
+
+	t.addCondition(rules.Spec{
+		Type:        "changedAfter",
+		Description: "The resource was last changed after the time",
+		Params:      []rules.Param{timeParam},
+		Validate:    validateTime,
+	}, t.timeCondition(changedTime, false))
+
+	t.addCondition(rules.Spec{
+		Type:        "olderThan",
+		Description: "The resource was created longer than the age ago",
+		Params:      []rules.Param{{Name: "age", Type: rules.StringParam, Required: true, Description: "Duration like 36h, or a number of days (7d) or weeks (2w)"}},
+		Validate:    validateAge,
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		age, err := parseAge(p.GetString("age"))
+		if err != nil || data.CreatedTime == nil {
+			return false
+		}
+		return data.CreatedTime.Before(t.currentTime().Add(-age))
+	})
+
+	t.addCondition(rules.Spec{
+		Type:        "resEqual",
+		Description: "The name of the resource equals the resource",
+		Params:      []rules.Param{{Name: "resource", Type: rules.StringParam, Required: true, Description: "Name of the resource"}},
+	}, func(p rules.ConditionItem, data *Resource) bool {
+		return data.Name != nil && p.GetString("resource") == *data.Name
+	})
+
+	t.addCondition(exprSpec, func(p rules.ConditionItem, data *Resource) bool {
+		return evalExpr(p.GetString("expression"), data)
+	})
+
+	t.addRegisteredConditions()
+}
+
+// currentTime returns the time ages of resources are computed from. It is the time of the first call, so that all the
+// resources of a run are compared with the same time
+func (t *Tagger) currentTime() time.Time {
+	if t.now.IsZero() {
+		t.now = time.Now()
 	}
+	return t.now
+}
 
-	t.condMap["resEqual"] = func(p map[string]string, data *Resource) bool {
-		if p["resourceGroup"] != *data.ResourceGroup {
-			return true
+func createdTime(r *Resource) *time.Time { return r.CreatedTime }
+
+func changedTime(r *Resource) *time.Time { return r.ChangedTime }
+
+// timeCondition returns the evaluation of conditions comparing the time of the resource returned by field with the
+// time parameter. Resources with an unknown time never match
+func (t *Tagger) timeCondition(field func(*Resource) *time.Time, before bool) func(p rules.ConditionItem, data *Resource) bool {
+	return func(p rules.ConditionItem, data *Resource) bool {
+		limit, err := parseTime(p.GetString("time"), t.currentTime())
+		v := field(data)
+		if err != nil || v == nil {
+			return false
 		}
-		return false
+		if before {
+			return v.Before(limit)
+		}
+		return v.After(limit)
+	}
+}
+
+// addCondition adds the implementation eval of conditions described by spec
+func (t *Tagger) addCondition(spec rules.Spec, eval func(p rules.ConditionItem, data *Resource) bool) {
+	t.condMap[spec.Type] = condition{spec: spec, eval: eval}
+}
+
+// addAction adds the implementation execute of actions described by spec
+func (t *Tagger) addAction(spec rules.Spec, execute func(p rules.ActionItem, data *Resource) (string, error)) {
+	t.actionMap[spec.Type] = action{spec: spec, execute: execute}
+}
+
+// Validate checks the conditions and actions of the rules against the supported types and their parameters.
+// Problems in version 1 of the rules, which have always been ignored, are only logged
+func (t *Tagger) Validate() error {
+	warnings, err := t.Rules.Validate(t.condSpecs(), t.actionSpecs())
+	for _, warning := range warnings {
+		log.Warn(warning)
+	}
+	if t.Rules.GetVersion() == rules.Version1 {
+		for i, rule := range t.Rules.Rules {
+			for j, cond := range rule.Conditions {
+				if cond.GetType() == "resEqual" {
+					log.Warnf("rule %d (%q): condition %d (resEqual) now matches the resources named resource, "+
+						"it used to match the resources outside of the resource group resourceGroup, see CHANGELOG.md", i, rule.Name, j)
+				}
+			}
+		}
+	}
+	return err
+}
+
+func (t *Tagger) condSpecs() map[string]rules.Spec {
+	specs := make(map[string]rules.Spec, len(t.condMap))
+	for name, cond := range t.condMap {
+		specs[name] = cond.spec
+	}
+	return specs
+}
+
+func (t *Tagger) actionSpecs() map[string]rules.Spec {
+	specs := make(map[string]rules.Spec, len(t.actionMap))
+	for name, action := range t.actionMap {
+		specs[name] = action.spec
+	}
+	return specs
+}
+
+// Specs returns the specs of the conditions and actions supported by the tagger, sorted by type
+func Specs() (conditions, actions []rules.Spec) {
+	tagger := Tagger{}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	return sortedSpecs(tagger.condSpecs()), sortedSpecs(tagger.actionSpecs())
+}
+
+func sortedSpecs(specs map[string]rules.Spec) []rules.Spec {
+	sorted := make([]rules.Spec, 0, len(specs))
+	for _, spec := range specs {
+		sorted = append(sorted, spec)
 	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Type < sorted[j].Type })
+	return sorted
+}
+
+// ValidateRules checks ruleDef against the conditions and actions supported by the tagger, see Tagger.Validate
+func ValidateRules(ruleDef rules.TagRules) error {
+	tagger := Tagger{Rules: ruleDef}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	return tagger.Validate()
+}
+
+// ResolveFiles returns ruleDef with the file parameters of conditions and actions relative to the rules file
+// defining them
+func ResolveFiles(ruleDef rules.TagRules) rules.TagRules {
+	tagger := Tagger{Rules: ruleDef}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	return ruleDef.ResolveFiles(tagger.condSpecs(), tagger.actionSpecs())
 }
 
 // ExecuteActions executes all actions based on definitions of rules. It resturns list of executed actions
 func (t *Tagger) ExecuteActions() ([]ActionExecution, error) {
 	ael := make([]ActionExecution, 0)
 	for resID, matched := range t.Matched {
-		for _, rule := range matched.TagRules {
-			ae := ActionExecution{
-				ResourceID: resID,
-				RuleName:   rule.Name,
-				Actions:    rule.Actions,
+		executions, err := t.executeMatched(resID, matched)
+		if err != nil {
+			return []ActionExecution{}, err
+		}
+		ael = append(ael, executions...)
+	}
+	return ael, nil
+}
+
+// executeMatched executes the actions of the rules matched by the resource resID
+func (t *Tagger) executeMatched(resID string, matched Matched) ([]ActionExecution, error) {
+	ael := make([]ActionExecution, 0, len(matched.TagRules))
+	for _, rule := range matched.TagRules {
+		ae := ActionExecution{
+			ResourceID:   resID,
+			Subscription: matched.Resource.SubscriptionID(),
+			RuleName:     rule.Name,
+			Mode:         rule.GetMode(),
+			Actions:      rule.Actions,
+			Rule:         rule,
+			Tags:         NewResourceDocument(&matched.Resource).Tags,
+		}
+		for _, action := range rule.Actions {
+			if rule.GetMode() != rules.ModeEnforce || (t.dryRun && !t.runsInDryRun(action)) {
+				continue
 			}
-			for _, action := range rule.Actions {
-				if t.dryRun != true {
-					resource := Resource{ID: resID}
-					err := t.Execute(&resource, action)
-					if err != nil {
-						msg := fmt.Sprintf("ExecuteActions(): Execute() failed Can't execute action [%s] on [%s], [%s]\n", action.GetType(), resource.ID, err)
-						return []ActionExecution{}, errors.New(msg)
-					}
-				}
+			resource := matched.Resource
+			resource.ID = resID
+			output, err := t.execute(&resource, action, rule)
+			if err != nil {
+				msg := fmt.Sprintf("ExecuteActions(): Execute() failed Can't execute action [%s] on [%s], [%s]\n", action.GetType(), resource.ID, err)
+				return ael, errors.New(msg)
+			}
+			if output != "" {
+				ae.Outputs = append(ae.Outputs, ActionOutput{Type: action.GetType(), Output: output})
 			}
-			ael = append(ael, ae)
 		}
+		ael = append(ael, ae)
 	}
 	return ael, nil
 }
 
 // EvaluateRules iterates over all rules and resources and checks which conditions are true.
 func (t Tagger) EvaluateRules(resources []Resource) {
-	var evaled bool
-
 	for _, resource := range resources {
-		evaled = true
-		for _, y := range t.Rules.Rules {
-			for _, cond := range y.Conditions {
-				evaled = t.Eval(&resource, cond)
-				if !evaled {
-					break
-				}
-			}
+		matched, ok := t.EvaluateResource(resource)
+		if !ok {
+			continue
+		}
+		if val, ok := t.Matched[resource.ID]; ok {
+			matched.TagRules = append(val.TagRules, matched.TagRules...)
+		}
+		t.Matched[resource.ID] = matched
+	}
+}
 
-			if evaled {
-				if val, ok := t.Matched[resource.ID]; ok {
-					matched := Matched{Resource: resource, TagRules: append(val.TagRules, y)}
-					t.Matched[resource.ID] = matched
-				} else {
-					matched := Matched{Resource: resource, TagRules: []rules.Rule{y}}
-					t.Matched[resource.ID] = matched
-				}
+// EvaluateResource checks which rules match the resource, without adding it to Matched
+func (t Tagger) EvaluateResource(resource Resource) (Matched, bool) {
+	matched := Matched{Resource: resource}
+	for _, y := range t.Rules.Rules {
+		if y.GetMode() == rules.ModeDisabled {
+			continue
+		}
+		evaled := true
+		for _, cond := range y.Conditions {
+			evaled = t.Eval(&resource, cond)
+			if !evaled {
+				break
 			}
 		}
+		if evaled {
+			matched.TagRules = append(matched.TagRules, y)
+		}
 	}
+	return matched, len(matched.TagRules) > 0
 }
 
 func (t Tagger) deleteAllTags(id string) error {
@@ -300,24 +742,145 @@ func (t Tagger) createOrUpdateTag(id, tag, value string) error {
 	return err
 }
 
+// addTags adds the tags the resource id does not have yet, in a single update
+func (t Tagger) addTags(id string, tags map[string]string) error {
+	r, err := t.ResourcesClient.GetByID(context.Background(), id)
+	if err != nil {
+		return errors.Wrap(err, "cannot get resource by id")
+	}
+
+	if r.Tags == nil {
+		r.Tags = make(map[string]*string)
+	}
+	changed := false
+	for tag, value := range tags {
+		if _, ok := r.Tags[tag]; ok {
+			continue
+		}
+		value := value
+		r.Tags[tag] = &value
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	genericResource := resources.GenericResource{
+		Tags: r.Tags,
+	}
+	_, err = t.ResourcesClient.UpdateByID(context.Background(), id, genericResource)
+	if err != nil {
+		return errors.Wrap(err, "cannot update resource by id")
+	}
+	return nil
+}
+
+// applyTagChanges sets and deletes the tags of the resource id, in a single update
+func (t Tagger) applyTagChanges(id string, changes TagChanges) error {
+	if len(changes.Set) == 0 && len(changes.Delete) == 0 {
+		return nil
+	}
+	r, err := t.ResourcesClient.GetByID(context.Background(), id)
+	if err != nil {
+		return errors.Wrap(err, "cannot get resource by id")
+	}
+
+	if r.Tags == nil {
+		r.Tags = make(map[string]*string)
+	}
+	changed := false
+	for tag, value := range changes.Set {
+		if old, ok := r.Tags[tag]; ok && old != nil && *old == value {
+			continue
+		}
+		value := value
+		r.Tags[tag] = &value
+		changed = true
+	}
+	for _, tag := range changes.Delete {
+		if _, ok := r.Tags[tag]; ok {
+			delete(r.Tags, tag)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	genericResource := resources.GenericResource{
+		Tags: r.Tags,
+	}
+	_, err = t.ResourcesClient.UpdateByID(context.Background(), id, genericResource)
+	if err != nil {
+		return errors.Wrap(err, "cannot update resource by id")
+	}
+	return nil
+}
+
+// deleteTags deletes the tags from the resource id, in a single update
+func (t Tagger) deleteTags(id string, tags []string) error {
+	r, err := t.ResourcesClient.GetByID(context.Background(), id)
+	if err != nil {
+		return errors.Wrapf(err, "deleteTags(id=%s): GetByID failed", id)
+	}
+
+	changed := false
+	for _, tag := range tags {
+		if _, ok := r.Tags[tag]; ok {
+			delete(r.Tags, tag)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	genericResource := resources.GenericResource{
+		Tags: r.Tags,
+	}
+	_, err = t.ResourcesClient.UpdateByID(context.Background(), id, genericResource)
+	if err != nil {
+		return errors.Wrapf(err, "deleteTags(id=%s): UpdateByID() failed", id)
+	}
+	return nil
+}
+
 // Execute executes action from p in resource data
 func (t *Tagger) Execute(data *Resource, p rules.ActionItem) error {
+	_, err := t.execute(data, p, rules.Rule{Actions: []rules.ActionItem{p}})
+	return err
+}
+
+// execute executes action from p of rule in resource data and returns the output of the action
+func (t *Tagger) execute(data *Resource, p rules.ActionItem, rule rules.Rule) (string, error) {
 	if val, ok := t.actionMap[p.GetType()]; ok {
-		err := val(p, data)
+		var output string
+		var err error
+		if val.executeRule != nil {
+			output, err = val.executeRule(p, data, rule)
+		} else {
+			output, err = val.execute(p, data)
+		}
 		if err != nil {
 			msg := fmt.Sprintf("Execute(action=%q) returned error %q", p.GetType(), err)
-			return errors.New(msg)
+			return output, errors.New(msg)
 		}
-		return nil
+		return output, nil
 	}
 	log.Warnf("Unknown action type %s - ignoring", p.GetType())
-	return nil
+	return "", nil
+}
+
+// runsInDryRun returns true if the action p is executed in dry runs as well
+func (t *Tagger) runsInDryRun(p rules.ActionItem) bool {
+	val, ok := t.actionMap[p.GetType()]
+	return ok && val.runsInDryRun != nil && val.runsInDryRun(p)
 }
 
 // Eval checks if condition p is satisfied on resource data
 func (t *Tagger) Eval(data *Resource, p rules.ConditionItem) bool {
 	if val, ok := t.condMap[p.GetType()]; ok {
-		return val(p, data)
+		return val.eval(p, data)
 	}
 	log.Warnf("Unknown condition type %s - ignoring", p.GetType())
 	return false
@@ -3,9 +3,11 @@ package azure
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2018-02-01/resources"
 	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/session"
 	"github.com/nordcloud/azure-tag-manager/mocks"
 	"github.com/stretchr/testify/assert"
 )
@@ -32,6 +34,23 @@ var (
 		},
 	}}
 
+	auditAndDisabled = rules.TagRules{Rules: []rules.Rule{
+		{Name: "audited", Mode: rules.ModeAudit, Conditions: []rules.ConditionItem{
+			{"type": "tagEqual", "tag": "test", "value": "test"},
+		},
+			Actions: []rules.ActionItem{
+				{"type": "addTag", "tag": "test2", "value": "test2"},
+			},
+		},
+		{Name: "disabled", Mode: rules.ModeDisabled, Conditions: []rules.ConditionItem{
+			{"type": "tagEqual", "tag": "test2", "value": "test2"},
+		},
+			Actions: []rules.ActionItem{
+				{"type": "cleanTags"},
+			},
+		},
+	}}
+
 	deleteAllTags = rules.TagRules{Rules: []rules.Rule{
 		{Name: "name", Conditions: []rules.ConditionItem{
 			{"type": "tagEqual", "tag": "test2", "value": "test2"},
@@ -45,8 +64,15 @@ var (
 
 var testResources = []Resource{
 	{ID: "1", Region: "westeurope", Tags: map[string]*string{"test": String("test")}, ResourceGroup: String("test"), Name: String("name")},
-	{ID: "2", Region: "westeurope", Tags: map[string]*string{"test2": String("test2"), "test3": String("test3")}, ResourceGroup: String("te3st"), Name: String("name2")},
-	{ID: "3", Region: "easteurope", Tags: map[string]*string{"test-region": String("other"), "othertest": String("test56")}, ResourceGroup: String("rg2"), Name: String("name3")},
+	{ID: "2", Region: "westeurope", Tags: map[string]*string{"test2": String("test2"), "test3": String("test3")}, ResourceGroup: String("te3st"), Name: String("name2"), ManagementGroups: []string{"root"},
+		SKU: "Premium_LRS", SKUTier: "Premium", ManagedBy: "/subscriptions/sub/resourceGroups/mc/providers/Microsoft.ContainerService/managedClusters/aks",
+		IdentityType: "SystemAssigned", ProvisioningState: "Succeeded", CreatedTime: timePtr(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))},
+	{ID: "3", Region: "easteurope", Tags: map[string]*string{"test-region": String("other"), "othertest": String("test56")}, ResourceGroup: String("rg2"), Name: String("name3"), ManagementGroups: []string{"root", "Corp"},
+		SKU: "Standard_LRS", Plan: "plan", ProvisioningState: "Failed", ChangedTime: timePtr(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))},
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
 }
 
 func TestTagger_ExecuteActions(t *testing.T) {
@@ -103,3 +129,192 @@ func TestTagger_ExecuteActions(t *testing.T) {
 		assert.Len(t, ael, 1)
 	})
 }
+
+func TestTagger_RuleModes(t *testing.T) {
+	t.Run("Test audit rule reports without writing", func(t *testing.T) {
+		mockClient := new(mocks.ClientAPI)
+		tagger := Tagger{
+			ResourcesClient: mockClient,
+			Rules:           auditAndDisabled,
+			Matched:         make(map[string]Matched),
+		}
+		tagger.InitActionMap()
+		tagger.InitCondMap()
+		tagger.EvaluateRules(testResources)
+		assert.Contains(t, tagger.Matched, "1")
+		assert.NotContains(t, tagger.Matched, "2")
+		ael, err := tagger.ExecuteActions()
+		assert.Nil(t, err)
+		assert.Len(t, ael, 1)
+		assert.True(t, ael[0].NonCompliant())
+		mockClient.AssertNumberOfCalls(t, "UpdateByID", 0)
+	})
+
+	t.Run("Test dryrun from rules", func(t *testing.T) {
+		dryRun := true
+		tagger := NewTagger(rules.TagRules{DryRun: &dryRun}, &session.AzureSession{SubscriptionID: "test"})
+		assert.True(t, tagger.IsDryRun())
+		tagger = NewTagger(rules.TagRules{}, &session.AzureSession{SubscriptionID: "test"})
+		assert.False(t, tagger.IsDryRun())
+	})
+}
+
+func TestTagger_ListConditions(t *testing.T) {
+	tests := []struct {
+		name string
+		cond rules.ConditionItem
+		want []string
+	}{
+		{name: "tagValueIn", cond: rules.ConditionItem{"type": "tagValueIn", "tag": "test", "values": []interface{}{"prod", "test"}}, want: []string{"1"}},
+		{name: "tagValueNotIn", cond: rules.ConditionItem{"type": "tagValueNotIn", "tag": "test2", "values": []interface{}{"prod"}}, want: []string{"2"}},
+		{name: "regionIn", cond: rules.ConditionItem{"type": "regionIn", "regions": []interface{}{"easteurope", "northeurope"}}, want: []string{"3"}},
+		{name: "regionNotIn", cond: rules.ConditionItem{"type": "regionNotIn", "regions": []interface{}{"easteurope"}}, want: []string{"1", "2"}},
+		{name: "rgIn", cond: rules.ConditionItem{"type": "rgIn", "resourceGroups": []interface{}{"test", "rg2"}}, want: []string{"1", "3"}},
+		{name: "mgUnder", cond: rules.ConditionItem{"type": "mgUnder", "managementGroup": "corp"}, want: []string{"3"}},
+		{name: "mgNotUnder", cond: rules.ConditionItem{"type": "mgNotUnder", "managementGroup": "corp"}, want: []string{"2"}},
+		{name: "resEqual", cond: rules.ConditionItem{"type": "resEqual", "resource": "name2"}, want: []string{"2"}},
+		{name: "skuEqual", cond: rules.ConditionItem{"type": "skuEqual", "sku": "premium_lrs"}, want: []string{"2"}},
+		{name: "skuIn", cond: rules.ConditionItem{"type": "skuIn", "skus": []interface{}{"Premium_LRS", "Standard_LRS"}}, want: []string{"2", "3"}},
+		{name: "skuTierEqual", cond: rules.ConditionItem{"type": "skuTierEqual", "tier": "Premium"}, want: []string{"2"}},
+		{name: "managedBy", cond: rules.ConditionItem{"type": "managedBy", "managedBy": "*/managedClusters/*"}, want: []string{"2"}},
+		{name: "notManaged", cond: rules.ConditionItem{"type": "notManaged"}, want: []string{"1", "3"}},
+		{name: "identityTypeEqual", cond: rules.ConditionItem{"type": "identityTypeEqual", "identityType": "None"}, want: []string{"1", "3"}},
+		{name: "planEqual", cond: rules.ConditionItem{"type": "planEqual", "plan": "plan"}, want: []string{"3"}},
+		{name: "provisioningStateEqual", cond: rules.ConditionItem{"type": "provisioningStateEqual", "state": "failed"}, want: []string{"3"}},
+		{name: "createdBefore", cond: rules.ConditionItem{"type": "createdBefore", "time": "2021-01-02"}, want: []string{"2"}},
+		{name: "createdAfter", cond: rules.ConditionItem{"type": "createdAfter", "time": "2021-01-01T00:00:00Z"}, want: nil},
+		{name: "changedBefore", cond: rules.ConditionItem{"type": "changedBefore", "time": "2021-01-01"}, want: []string{"3"}},
+		{name: "changedAfter", cond: rules.ConditionItem{"type": "changedAfter", "time": "2020-05-31T23:00:00+02:00"}, want: []string{"3"}},
+		{name: "expr on the sku", cond: rules.ConditionItem{"type": "expr", "expression": `sku.endsWith("_LRS") && managedBy == ""`}, want: []string{"3"}},
+		{name: "expr", cond: rules.ConditionItem{"type": "expr", "expression": `tags["test2"].startsWith("te") || (region != "westeurope" && resourceGroup.contains("2"))`}, want: []string{"2", "3"}},
+		{name: "expr on a missing tag", cond: rules.ConditionItem{"type": "expr", "expression": `tags["test"] == "test"`}, want: []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tagger := Tagger{Matched: make(map[string]Matched)}
+			tagger.InitCondMap()
+			var got []string
+			for _, res := range testResources {
+				res := res
+				if tagger.Eval(&res, tt.cond) {
+					got = append(got, res.ID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTagger_ConditionsWithoutResourceGroup(t *testing.T) {
+	tagger := Tagger{Matched: make(map[string]Matched)}
+	tagger.InitCondMap()
+	// subscription level resources, and imported ones, have no resource group
+	res := Resource{ID: "/subscriptions/sub/providers/Microsoft.Authorization/roleAssignments/ra", Name: String("ra")}
+	for cond, want := range map[string]bool{
+		"rgEqual":    false,
+		"rgNotEqual": true,
+		"rgIn":       false,
+		"rgNotIn":    true,
+	} {
+		item := rules.ConditionItem{"type": cond, "resourceGroup": "rg", "resourceGroups": []interface{}{"rg"}}
+		assert.Equal(t, want, tagger.Eval(&res, item), cond)
+	}
+}
+
+func TestTagger_AgeConditions(t *testing.T) {
+	now := time.Date(2021, 1, 7, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		cond rules.ConditionItem
+		want []string
+	}{
+		{name: "created in the last 7 days", cond: rules.ConditionItem{"type": "createdAfter", "time": "7d"}, want: []string{"2"}},
+		{name: "created before a week ago", cond: rules.ConditionItem{"type": "createdBefore", "time": "1w"}, want: nil},
+		{name: "not changed in 180 days", cond: rules.ConditionItem{"type": "changedBefore", "time": "180d"}, want: []string{"3"}},
+		{name: "changed in the last 36 hours", cond: rules.ConditionItem{"type": "changedAfter", "time": "36h"}, want: nil},
+		{name: "olderThan", cond: rules.ConditionItem{"type": "olderThan", "age": "6d"}, want: []string{"2"}},
+		{name: "not olderThan", cond: rules.ConditionItem{"type": "olderThan", "age": "7d"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tagger := Tagger{Matched: make(map[string]Matched), now: now}
+			tagger.InitCondMap()
+			var got []string
+			for _, res := range testResources {
+				res := res
+				if tagger.Eval(&res, tt.cond) {
+					got = append(got, res.ID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTagger_CurrentTime(t *testing.T) {
+	tagger := Tagger{}
+	first := tagger.currentTime()
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, first, tagger.currentTime(), "all the resources of a run are compared with the same time")
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		age     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{age: "36h", want: 36 * time.Hour},
+		{age: "7d", want: 7 * 24 * time.Hour},
+		{age: "2w", want: 14 * 24 * time.Hour},
+		{age: "0d", want: 0},
+		{age: "106751d", want: 106751 * 24 * time.Hour},
+		{age: "200000d", wantErr: true},
+		{age: "99999999999999999999w", wantErr: true},
+		{age: "-1d", wantErr: true},
+		{age: "d", wantErr: true},
+		{age: "7 days", wantErr: true},
+		{age: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAge(tt.age)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.age)
+			continue
+		}
+		assert.Nil(t, err, tt.age)
+		assert.Equal(t, tt.want, got, tt.age)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	unknown := rules.TagRules{Rules: []rules.Rule{
+		{Name: "name", Conditions: []rules.ConditionItem{{"type": "tagIs", "tag": "test"}}},
+	}}
+	assert.Nil(t, ValidateRules(twoRulesWant))
+	assert.Nil(t, ValidateRules(unknown), "version 1 rules are only warned about")
+
+	unknown.Version = rules.Version2
+	assert.NotNil(t, ValidateRules(unknown))
+
+	invalidExpr := rules.TagRules{Rules: []rules.Rule{
+		{Name: "name", Conditions: []rules.ConditionItem{{"type": "expr", "expression": `region + 1`}}},
+	}}
+	assert.NotNil(t, ValidateRules(invalidExpr), "expressions are type checked in version 1 as well")
+	invalidExpr.Rules[0].Conditions[0]["expression"] = `tags["env"]`
+	assert.NotNil(t, ValidateRules(invalidExpr))
+
+	invalidTime := rules.TagRules{Rules: []rules.Rule{
+		{Name: "name", Conditions: []rules.ConditionItem{{"type": "createdAfter", "time": "last week"}, {"type": "olderThan", "age": "7d"}}},
+	}}
+	assert.NotNil(t, ValidateRules(invalidTime))
+	invalidTime.Rules[0].Conditions[0]["time"] = "2021-01-31"
+	assert.Nil(t, ValidateRules(invalidTime))
+
+	missing := rules.TagRules{Version: rules.Version2, Rules: []rules.Rule{
+		{Name: "name", Actions: []rules.ActionItem{{"type": "addTags", "tag": "test"}}},
+	}}
+	assert.EqualError(t, ValidateRules(missing), "2 errors occurred:\n\t* rule 0 (\"name\"): action 0: addTags: parameter \"tags\" is required\n\t* rule 0 (\"name\"): action 0: addTags: unknown parameter \"tag\", expected one of tags")
+}
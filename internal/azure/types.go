@@ -1,16 +1,257 @@
 package azure
 
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
 //Resource represents a generic resource with name, region, id, tags and resource group
 type Resource struct {
-	Platform      string
-	Name          *string
-	Region        string
-	ID            string
-	Kind          *string
-	Type          *string
-	Tags          map[string]*string
-	ResourceGroup *string
-}
-
-type condFuncMap map[string]func(p map[string]string, data *Resource) bool
-type actionFuncMap map[string]func(p map[string]string, data *Resource) error
+	Platform         string
+	Name             *string
+	Region           string
+	ID               string
+	Kind             *string
+	Type             *string
+	Tags             map[string]*string
+	ResourceGroup    *string
+	Subscription     string   // ID of the subscription, set by scanners
+	ManagementGroups []string // names of the management groups above the subscription, from the root, if known
+
+	SKU               string     // name of the SKU, like Premium_LRS
+	SKUTier           string     // tier of the SKU, like Premium
+	ManagedBy         string     // ID of the resource managing the resource, like an AKS cluster
+	IdentityType      string     // type of the managed identity, like SystemAssigned
+	Plan              string     // name of the marketplace plan
+	ProvisioningState string     // like Succeeded or Failed
+	CreatedTime       *time.Time // nil if not known
+	ChangedTime       *time.Time // nil if not known
+}
+
+// SubscriptionID returns the ID of the subscription of the resource, taken from its ID if the scanner didn't set it
+func (r Resource) SubscriptionID() string {
+	if r.Subscription != "" {
+		return r.Subscription
+	}
+	return subscriptionFromID(r.ID)
+}
+
+// subscriptionFromID returns the subscription in the resource id
+func subscriptionFromID(id string) string {
+	return idSegment(id, "subscriptions")
+}
+
+// resourceGroupFromID returns the resource group in the resource id
+func resourceGroupFromID(id string) string {
+	return idSegment(id, "resourceGroups")
+}
+
+// idSegment returns the segment of the resource id after the segment key
+func idSegment(id, key string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], key) {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// condition is the implementation of a type of conditions
+type condition struct {
+	spec rules.Spec
+	eval func(p rules.ConditionItem, data *Resource) bool
+}
+
+// action is the implementation of a type of actions. execute returns the output of the action, shown in reports
+type action struct {
+	spec         rules.Spec
+	execute      func(p rules.ActionItem, data *Resource) (string, error)
+	executeRule  func(p rules.ActionItem, data *Resource, rule rules.Rule) (string, error) // optional, instead of execute for actions using their rule
+	runsInDryRun func(p rules.ActionItem) bool                                             // optional, the action checks Tagger.IsDryRun itself
+}
+
+// ResourceDocument is the JSON representation of a resource given to commands and plugins
+type ResourceDocument struct {
+	ID               string            `json:"id"`
+	Subscription     string            `json:"subscription"`
+	Name             string            `json:"name"`
+	Region           string            `json:"region"`
+	ResourceGroup    string            `json:"resourceGroup"`
+	Type             string            `json:"type"`
+	Kind             string            `json:"kind"`
+	Tags             map[string]string `json:"tags"`
+	ManagementGroups []string          `json:"managementGroups,omitempty"`
+
+	SKU               string     `json:"sku,omitempty"`
+	SKUTier           string     `json:"skuTier,omitempty"`
+	ManagedBy         string     `json:"managedBy,omitempty"`
+	IdentityType      string     `json:"identityType,omitempty"`
+	Plan              string     `json:"plan,omitempty"`
+	ProvisioningState string     `json:"provisioningState,omitempty"`
+	CreatedTime       *time.Time `json:"createdTime,omitempty"`
+	ChangedTime       *time.Time `json:"changedTime,omitempty"`
+}
+
+// NewResourceDocument returns the JSON representation of data
+func NewResourceDocument(data *Resource) ResourceDocument {
+	return ResourceDocument{
+		ID:               data.ID,
+		Subscription:     data.SubscriptionID(),
+		Name:             stringValue(data.Name),
+		Region:           data.Region,
+		ResourceGroup:    stringValue(data.ResourceGroup),
+		Type:             stringValue(data.Type),
+		Kind:             stringValue(data.Kind),
+		Tags:             stringTags(data.Tags),
+		ManagementGroups: data.ManagementGroups,
+
+		SKU:               data.SKU,
+		SKUTier:           data.SKUTier,
+		ManagedBy:         data.ManagedBy,
+		IdentityType:      data.IdentityType,
+		Plan:              data.Plan,
+		ProvisioningState: data.ProvisioningState,
+		CreatedTime:       data.CreatedTime,
+		ChangedTime:       data.ChangedTime,
+	}
+}
+
+// Resource returns the resource represented by d
+func (d ResourceDocument) Resource() Resource {
+	tags := make(map[string]*string, len(d.Tags))
+	for k, v := range d.Tags {
+		tags[k] = String(v)
+	}
+	return Resource{
+		Platform:          "azure",
+		ID:                d.ID,
+		Name:              String(d.Name),
+		Region:            d.Region,
+		Kind:              String(d.Kind),
+		Type:              String(d.Type),
+		Tags:              tags,
+		ResourceGroup:     String(d.ResourceGroup),
+		Subscription:      d.Subscription,
+		ManagementGroups:  d.ManagementGroups,
+		SKU:               d.SKU,
+		SKUTier:           d.SKUTier,
+		ManagedBy:         d.ManagedBy,
+		IdentityType:      d.IdentityType,
+		Plan:              d.Plan,
+		ProvisioningState: d.ProvisioningState,
+		CreatedTime:       d.CreatedTime,
+		ChangedTime:       d.ChangedTime,
+	}
+}
+
+// stringTags returns the tags without the ones without value
+func stringTags(tags map[string]*string) map[string]string {
+	values := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if v != nil {
+			values[k] = *v
+		}
+	}
+	return values
+}
+
+type condFuncMap map[string]condition
+type actionFuncMap map[string]action
+
+// Parameters shared by conditions and actions
+var (
+	tagParam             = rules.Param{Name: "tag", Type: rules.StringParam, Required: true, Description: "Key of the tag"}
+	valueParam           = rules.Param{Name: "value", Type: rules.StringParam, Required: true, Description: "Value of the tag"}
+	valuesParam          = rules.Param{Name: "values", Type: rules.StringListParam, Required: true, Description: "Values of the tag"}
+	regionParam          = rules.Param{Name: "region", Type: rules.StringParam, Required: true, Description: "Region (location) of the resource"}
+	regionsParam         = rules.Param{Name: "regions", Type: rules.StringListParam, Required: true, Description: "Regions (locations) of the resource"}
+	resourceGroupParam   = rules.Param{Name: "resourceGroup", Type: rules.StringParam, Required: true, Description: "Name of the resource group"}
+	resourceGroupsParam  = rules.Param{Name: "resourceGroups", Type: rules.StringListParam, Required: true, Description: "Names of the resource groups"}
+	managementGroupParam = rules.Param{Name: "managementGroup", Type: rules.StringParam, Required: true, Description: "Name (ID) of the management group"}
+	skuParam             = rules.Param{Name: "sku", Type: rules.StringParam, Required: true, Description: "Name of the SKU, like Premium_LRS"}
+	skusParam            = rules.Param{Name: "skus", Type: rules.StringListParam, Required: true, Description: "Names of the SKUs"}
+	timeParam            = rules.Param{Name: "time", Type: rules.StringParam, Required: true, Description: "Date (2006-01-02), RFC 3339 time or age before now, like 7d"}
+)
+
+// timeLayouts are the layouts of times given in conditions
+var timeLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// ageUnits are the units of ages given in conditions in addition to the ones of time.ParseDuration
+var ageUnits = map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+
+// parseTime parses a time given in a condition, a date, an RFC 3339 time or an age before now, like 7d
+func parseTime(s string, now time.Time) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if age, err := parseAge(s); err == nil {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, errors.Errorf("invalid time %q, expected a date (2006-01-02), an RFC 3339 time or an age like 7d", s)
+}
+
+// parseAge parses an age given in a condition, a duration like 36h or a number of days (7d) or weeks (2w)
+func parseAge(s string) (time.Duration, error) {
+	if unit, ok := ageUnits[suffix(s)]; ok {
+		n, err := strconv.ParseInt(strings.TrimSuffix(s, suffix(s)), 10, 64)
+		if err != nil || n < 0 {
+			return 0, errors.Errorf("invalid age %q", s)
+		}
+		if n > math.MaxInt64/int64(unit) {
+			return 0, errors.Errorf("age %q is too long", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, errors.Errorf("invalid age %q, expected a duration like 36h, 7d or 2w", s)
+	}
+	return age, nil
+}
+
+func suffix(s string) string {
+	if s == "" {
+		return ""
+	}
+	return s[len(s)-1:]
+}
+
+// validateTime validates the time parameter of conditions
+func validateTime(item map[string]interface{}) error {
+	_, err := parseTime(rules.ConditionItem(item).GetString("time"), time.Now())
+	return err
+}
+
+// validateAge validates the age parameter of conditions
+func validateAge(item map[string]interface{}) error {
+	_, err := parseAge(rules.ConditionItem(item).GetString("age"))
+	return err
+}
+
+// containsFold returns true if list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, elem := range list {
+		if strings.EqualFold(elem, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
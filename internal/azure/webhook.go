@@ -0,0 +1,94 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/internal/webhook"
+)
+
+// webhookSpec describes actions posting the matched resource to a webhook
+var webhookSpec = rules.Spec{
+	Type:        "webhook",
+	Description: "Posts the resource, the rule and the changes of the rule to a webhook",
+	Params: []rules.Param{
+		{Name: "url", Type: rules.StringParam, Required: true, Description: "URL of the webhook"},
+		{Name: "format", Type: rules.StringParam, Description: "Format of the payload: json (default), slack or teams"},
+		{Name: "template", Type: rules.StringParam, Description: "Go template of the payload, instead of the format"},
+		{Name: "secret", Type: rules.StringParam, Description: "Secret signing the payload with HMAC-SHA256"},
+		{Name: "dryRun", Type: rules.StringParam, Description: "If true, the webhook is called in dry runs as well"},
+	},
+	Validate: func(item map[string]interface{}) error {
+		p := rules.ActionItem(item)
+		if format := p.GetString("format"); format != "" {
+			return webhook.CheckFormat(format)
+		}
+		return nil
+	},
+}
+
+// RuleDocument is the JSON representation of the metadata of a rule, sent to webhooks
+type RuleDocument struct {
+	Name        string            `json:"name"`
+	Mode        string            `json:"mode"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Ticket      string            `json:"ticket,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// ActionEvent is posted by webhook actions
+type ActionEvent struct {
+	Event    string             `json:"event"` // always action
+	DryRun   bool               `json:"dryRun"`
+	Rule     RuleDocument       `json:"rule"`
+	Resource ResourceDocument   `json:"resource"`
+	Changes  []rules.ActionItem `json:"changes"` // the other actions of the rule
+}
+
+// callWebhook posts the resource data and the rule of the action p to the webhook of the action
+func (t *Tagger) callWebhook(p rules.ActionItem, data *Resource, rule rules.Rule) (string, error) {
+	event := ActionEvent{
+		Event:  "action",
+		DryRun: t.dryRun,
+		Rule: RuleDocument{
+			Name:        rule.Name,
+			Mode:        rule.GetMode(),
+			Description: rule.Description,
+			Owner:       rule.Owner,
+			Ticket:      rule.Ticket,
+			Severity:    rule.Severity,
+			Labels:      rule.Labels,
+		},
+		Resource: NewResourceDocument(data),
+		Changes:  []rules.ActionItem{},
+	}
+	var changes []string
+	for _, action := range rule.Actions {
+		if action.GetType() != webhookSpec.Type {
+			event.Changes = append(event.Changes, action)
+			changes = append(changes, action.String())
+		}
+	}
+
+	title := fmt.Sprintf("Rule %s matched %s", rule.Name, event.Resource.Name)
+	if t.dryRun {
+		title += " (dry run)"
+	}
+	text := fmt.Sprintf("Resource: %s\nChanges: %s", data.ID, strings.Join(changes, ", "))
+	if metadata := rule.MetadataString(); metadata != "" {
+		text += "\nMetadata: " + metadata
+	}
+
+	sender := webhook.NewSender(p.GetString("secret"))
+	sender.Template = p.GetString("template")
+	if err := sender.Send(context.Background(), p.GetString("url"), p.GetString("format"), webhook.Message{Title: title, Text: text, Data: event}); err != nil {
+		return "", errors.Wrapf(err, "Action webhook failed for resource %s", data.ID)
+	}
+	return "", nil
+}
@@ -0,0 +1,46 @@
+package azure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+	"github.com/nordcloud/azure-tag-manager/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagger_WebhookAction(t *testing.T) {
+	var events []ActionEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event ActionEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	ruleDef := execRules(
+		rules.ActionItem{"type": "webhook", "url": server.URL, "dryRun": "true"},
+		rules.ActionItem{"type": "addTag", "tag": "env", "value": "dev"},
+	)
+	ruleDef.Rules[0].Owner = "platform"
+	tagger := Tagger{
+		ResourcesClient: new(mocks.ClientAPI),
+		Rules:           ruleDef,
+		Matched:         make(map[string]Matched),
+		dryRun:          true,
+	}
+	tagger.InitActionMap()
+	tagger.InitCondMap()
+	tagger.EvaluateRules(testResources)
+	_, err := tagger.ExecuteActions()
+	assert.Nil(t, err)
+	assert.Equal(t, []ActionEvent{{
+		Event:    "action",
+		DryRun:   true,
+		Rule:     RuleDocument{Name: "exec", Mode: rules.ModeEnforce, Owner: "platform"},
+		Resource: NewResourceDocument(&testResources[0]),
+		Changes:  []rules.ActionItem{{"type": "addTag", "tag": "env", "value": "dev"}},
+	}}, events)
+}
@@ -0,0 +1,112 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+)
+
+// DefaultSubject is the default template of the subject of digests
+const DefaultSubject = `tagmanager {{.Command}}: {{len .Resources}} of your resource(s){{if .DryRun}} (dry run){{end}}`
+
+// DefaultBody is the default template of the body of digests
+const DefaultBody = `Hello,
+{{if .DryRun}}
+tagmanager {{.Command}} ran in dry run mode, nothing was changed.
+{{end}}
+These are the results of tagmanager {{.Command}} on the resources owned by {{.Owner}}:
+{{range .Resources}}
+{{.ID}}
+{{- range .Executions}}
+{{- if .NonCompliant}}
+  - non-compliant with rule {{.RuleName}}{{if .Rule.Description}}: {{.Rule.Description}}{{end}}
+{{- else}}
+  - rule {{.RuleName}}{{if .Rule.Description}}: {{.Rule.Description}}{{end}}
+{{- range .Actions}}
+      {{.}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{end}}`
+
+// Digest holds the results of a run on the resources of an owner
+type Digest struct {
+	Owner     string // the value of the owner tag, empty for resources without it
+	Command   string
+	DryRun    bool
+	Resources []DigestResource
+}
+
+// DigestResource holds the rule executions on a resource
+type DigestResource struct {
+	ID         string
+	Executions []azure.ActionExecution
+}
+
+// NewDigests groups ael by the owner tag of the resources. The digest of the resources without the tag,
+// if any, is the first one
+func NewDigests(ael []azure.ActionExecution, ownerTag, command string, dryRun bool) []Digest {
+	owners, groups := azure.GroupExecutionsByTag(ael, ownerTag)
+	digests := make([]Digest, 0, len(owners))
+	for _, owner := range owners {
+		digest := Digest{Owner: owner, Command: command, DryRun: dryRun}
+		if owner == azure.NoGroup {
+			digest.Owner = ""
+		}
+		for _, ae := range groups[owner] {
+			last := len(digest.Resources) - 1
+			if last < 0 || digest.Resources[last].ID != ae.ResourceID {
+				digest.Resources = append(digest.Resources, DigestResource{ID: ae.ResourceID})
+				last++
+			}
+			digest.Resources[last].Executions = append(digest.Resources[last].Executions, ae)
+		}
+		digests = append(digests, digest)
+	}
+	return digests
+}
+
+// Merge adds the resources of other to the digest, for owners sharing an address
+func (d *Digest) Merge(other Digest) {
+	if d.Owner == "" {
+		d.Owner = other.Owner
+	}
+	d.Resources = append(d.Resources, other.Resources...)
+}
+
+// Renderer renders digests into emails with text templates
+type Renderer struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewRenderer parses the templates of the subject and of the body of digests
+func NewRenderer(subject, body string) (*Renderer, error) {
+	s, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid subject template")
+	}
+	b, err := template.New("body").Parse(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid body template")
+	}
+	return &Renderer{subject: s, body: b}, nil
+}
+
+// Render renders digest into an email from from to to
+func (r *Renderer) Render(digest Digest, from, to string) (Message, error) {
+	var subject, body bytes.Buffer
+	if err := r.subject.Execute(&subject, digest); err != nil {
+		return Message{}, errors.Wrap(err, "can't render the subject of the digest")
+	}
+	if err := r.body.Execute(&body, digest); err != nil {
+		return Message{}, errors.Wrap(err, "can't render the body of the digest")
+	}
+	// a subject on several lines would inject headers
+	oneLine := strings.Join(strings.Fields(subject.String()), " ")
+	return Message{From: from, To: []string{to}, Subject: oneLine, Body: body.String()}, nil
+}
@@ -0,0 +1,88 @@
+package mail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+func testExecutions() []azure.ActionExecution {
+	owned := map[string]string{"owner": "ann@example.com"}
+	return []azure.ActionExecution{
+		{
+			ResourceID: "/vm1",
+			RuleName:   "env",
+			Mode:       rules.ModeEnforce,
+			Rule:       rules.Rule{Name: "env", Description: "Every resource has an env"},
+			Actions:    []rules.ActionItem{{"type": "addTag", "tag": "env", "value": "dev"}},
+			Tags:       owned,
+		},
+		{ResourceID: "/vm1", RuleName: "costs", Mode: rules.ModeAudit, Rule: rules.Rule{Name: "costs"}, Tags: owned},
+		{ResourceID: "/vm2", RuleName: "costs", Mode: rules.ModeAudit, Rule: rules.Rule{Name: "costs"}, Tags: owned},
+		{ResourceID: "/vm3", RuleName: "costs", Mode: rules.ModeAudit, Rule: rules.Rule{Name: "costs"}},
+	}
+}
+
+func TestNewDigests(t *testing.T) {
+	digests := NewDigests(testExecutions(), "owner", "rewrite", false)
+	if !assert.Len(t, digests, 2) {
+		return
+	}
+	assert.Equal(t, "", digests[0].Owner)
+	assert.Equal(t, []string{"/vm3"}, resourceIDs(digests[0]))
+	assert.Equal(t, "ann@example.com", digests[1].Owner)
+	assert.Equal(t, []string{"/vm1", "/vm2"}, resourceIDs(digests[1]))
+	assert.Len(t, digests[1].Resources[0].Executions, 2)
+
+	digests[0].Merge(digests[1])
+	assert.Equal(t, "ann@example.com", digests[0].Owner)
+	assert.Equal(t, []string{"/vm3", "/vm1", "/vm2"}, resourceIDs(digests[0]))
+}
+
+func TestRenderer_Render(t *testing.T) {
+	renderer, err := NewRenderer(DefaultSubject, DefaultBody)
+	if !assert.Nil(t, err) {
+		return
+	}
+	digest := NewDigests(testExecutions(), "owner", "rewrite", true)[1]
+
+	msg, err := renderer.Render(digest, "tagmanager@example.com", "ann@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ann@example.com"}, msg.To)
+	assert.Equal(t, "tagmanager rewrite: 2 of your resource(s) (dry run)", msg.Subject)
+	assert.Equal(t, `Hello,
+
+tagmanager rewrite ran in dry run mode, nothing was changed.
+
+These are the results of tagmanager rewrite on the resources owned by ann@example.com:
+
+/vm1
+  - non-compliant with rule costs
+  - rule env: Every resource has an env
+      addTag(tag=env, value=dev)
+
+/vm2
+  - non-compliant with rule costs
+`, msg.Body)
+
+	renderer, err = NewRenderer("{{.Owner}}\nBcc: eve@example.com", "{{.Command}}")
+	assert.Nil(t, err)
+	msg, err = renderer.Render(digest, "tagmanager@example.com", "ann@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "ann@example.com Bcc: eve@example.com", msg.Subject)
+	assert.Equal(t, "rewrite", msg.Body)
+
+	_, err = NewRenderer("{{.Owner", DefaultBody)
+	assert.NotNil(t, err)
+}
+
+func resourceIDs(digest Digest) []string {
+	var ids []string
+	for _, r := range digest.Resources {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
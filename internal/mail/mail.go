@@ -0,0 +1,185 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a plain text email
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	Date    time.Time // the time of sending if zero
+}
+
+// Bytes returns the message in the RFC 5322 format, with a quoted-printable UTF-8 body
+func (m Message) Bytes() []byte {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	id := make([]byte, 12)
+	rand.Read(id)
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", m.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@tagmanager>", hex.EncodeToString(id)))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	body := strings.Replace(m.Body, "\r\n", "\n", -1)
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(strings.Replace(body, "\n", "\r\n", -1)))
+	w.Close()
+	return buf.Bytes()
+}
+
+// Address returns the email address of owner, appending @domain to owners which are not addresses
+func Address(owner, domain string) (string, error) {
+	if !strings.Contains(owner, "@") && domain != "" {
+		owner += "@" + strings.TrimPrefix(domain, "@")
+	}
+	addr, err := mail.ParseAddress(owner)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid address %q", owner)
+	}
+	return addr.Address, nil
+}
+
+// Mailer sends messages with SMTP. The connection is always upgraded with STARTTLS before authenticating
+type Mailer struct {
+	Addr      string // host:port of the SMTP server
+	Username  string // authenticates with PLAIN if set
+	Password  string
+	TLSConfig *tls.Config   // the default verifies the certificate of the host of Addr
+	Timeout   time.Duration // of connecting and of sending each message, 30s if zero
+}
+
+// Send sends msgs in a single SMTP session
+func (m *Mailer) Send(msgs ...Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid SMTP server %q", m.Addr)
+	}
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", m.Addr, timeout)
+	if err != nil {
+		return errors.Wrap(err, "can't connect to the SMTP server")
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "can't connect to the SMTP server")
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return errors.Errorf("SMTP server %s does not support STARTTLS", m.Addr)
+	}
+	tlsConfig := m.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+	if err := c.StartTLS(tlsConfig); err != nil {
+		return errors.Wrap(err, "STARTTLS failed")
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return errors.Wrap(err, "SMTP authentication failed")
+		}
+	}
+
+	for _, msg := range msgs {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if err := send(c, msg); err != nil {
+			return errors.Wrapf(err, "can't send email to %s", strings.Join(msg.To, ", "))
+		}
+	}
+	return c.Quit()
+}
+
+func send(c *smtp.Client, msg Message) error {
+	if err := c.Mail(msg.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// WriteDir writes msgs to dir as .eml files named after their first recipient, and returns their paths. Messages
+// to the same recipient are numbered
+func WriteDir(dir string, msgs ...Message) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "can't create the email directory")
+	}
+	var paths []string
+	names := make(map[string]int)
+	for _, msg := range msgs {
+		name := "unknown"
+		if len(msg.To) > 0 {
+			name = fileName(msg.To[0])
+		}
+		names[strings.ToLower(name)]++
+		if n := names[strings.ToLower(name)]; n > 1 {
+			name = fmt.Sprintf("%s-%d", name, n)
+		}
+		path := filepath.Join(dir, name+".eml")
+		if err := ioutil.WriteFile(path, msg.Bytes(), 0644); err != nil {
+			return paths, errors.Wrap(err, "can't write email")
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// fileName replaces the characters of addr which are not safe in file names
+func fileName(addr string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("@.-_+", r):
+			return r
+		}
+		return '_'
+	}, addr)
+}
@@ -0,0 +1,230 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Bytes(t *testing.T) {
+	msg := Message{
+		From:    "tagmanager@example.com",
+		To:      []string{"ann@example.com"},
+		Subject: "Tags of your résources",
+		Body:    "line 1\nline 2",
+		Date:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg.Bytes())))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "tagmanager@example.com", parsed.Header.Get("From"))
+	assert.Equal(t, "ann@example.com", parsed.Header.Get("To"))
+	assert.Equal(t, "Thu, 02 Jan 2020 03:04:05 +0000", parsed.Header.Get("Date"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Tags of your résources", subject)
+	body, _ := ioutil.ReadAll(parsed.Body)
+	assert.Equal(t, "line 1\r\nline 2", string(body))
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		owner, domain, want string
+		wantErr             bool
+	}{
+		{"ann@example.com", "", "ann@example.com", false},
+		{"ann@example.com", "example.org", "ann@example.com", false},
+		{"Ann <ann@example.com>", "", "ann@example.com", false},
+		{"ann", "example.org", "ann@example.org", false},
+		{"ann", "@example.org", "ann@example.org", false},
+		{"ann", "", "", true},
+		{"platform team", "example.org", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Address(tt.owner, tt.domain)
+		assert.Equal(t, tt.wantErr, err != nil, tt.owner)
+		assert.Equal(t, tt.want, got, tt.owner)
+	}
+}
+
+func TestWriteDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "digests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := WriteDir(filepath.Join(dir, "out"), Message{To: []string{"ann@example.com"}}, Message{To: []string{"bob/../x@example.com"}}, Message{To: []string{"Ann@example.com"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "out", "ann@example.com.eml"),
+		filepath.Join(dir, "out", "bob_.._x@example.com.eml"),
+		filepath.Join(dir, "out", "Ann@example.com-2.eml"),
+	}, paths)
+	dat, err := ioutil.ReadFile(paths[0])
+	assert.Nil(t, err)
+	assert.Contains(t, string(dat), "To: ann@example.com\r\n")
+}
+
+func TestMailer_Send(t *testing.T) {
+	cert := testCertificate(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	transcript := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		transcript <- fakeSMTP(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	mailer := &Mailer{
+		Addr:      l.Addr().String(),
+		Username:  "user",
+		Password:  "secret",
+		TLSConfig: &tls.Config{ServerName: "localhost", RootCAs: pool},
+		Timeout:   5 * time.Second,
+	}
+	err = mailer.Send(
+		Message{From: "tagmanager@example.com", To: []string{"ann@example.com"}, Subject: "digest", Body: "hello ann"},
+		Message{From: "tagmanager@example.com", To: []string{"bob@example.com"}, Subject: "digest", Body: "hello bob"},
+	)
+	assert.Nil(t, err)
+
+	lines := <-transcript
+	assert.Contains(t, lines, "STARTTLS")
+	assert.Contains(t, lines, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret")))
+	assert.Contains(t, lines, "RCPT TO:<ann@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<bob@example.com>")
+	assert.Contains(t, lines, "hello bob")
+	assert.Equal(t, "QUIT", lines[len(lines)-1])
+}
+
+func TestMailer_SendWithoutSTARTTLS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		fakeSMTP(conn, nil)
+	}()
+
+	mailer := &Mailer{Addr: l.Addr().String(), Username: "user", Password: "secret", Timeout: 5 * time.Second}
+	err = mailer.Send(Message{From: "tagmanager@example.com", To: []string{"ann@example.com"}})
+	assert.EqualError(t, err, "SMTP server "+l.Addr().String()+" does not support STARTTLS")
+}
+
+// fakeSMTP serves a single SMTP session on conn, offering STARTTLS if tlsConfig is set, and returns the lines it received
+func fakeSMTP(conn net.Conn, tlsConfig *tls.Config) []string {
+	defer conn.Close()
+	var lines []string
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	reply := func(s string) {
+		w.WriteString(s + "\r\n")
+		w.Flush()
+	}
+
+	reply("220 localhost ESMTP")
+	data := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return lines
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if data {
+			if line == "." {
+				data = false
+				reply("250 queued")
+			}
+			continue
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO":
+			if tlsConfig != nil {
+				reply("250-localhost\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			} else {
+				reply("250-localhost\r\n250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return lines
+			}
+			conn = tlsConn
+			r, w = bufio.NewReader(conn), bufio.NewWriter(conn)
+			tlsConfig = nil
+		case "AUTH":
+			reply("235 authenticated")
+		case "DATA":
+			data = true
+			reply("354 go ahead")
+		case "QUIT":
+			reply("221 bye")
+			return lines
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for localhost
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats of payloads
+const (
+	FormatJSON  = "json"  // the data of the message as it is
+	FormatSlack = "slack" // a Slack incoming webhook message
+	FormatTeams = "teams" // a Microsoft Teams connector card
+)
+
+// SignatureHeader holds the HMAC-SHA256 of the body, in the form sha256=<hex>, if a secret is set
+const SignatureHeader = "X-Tagmanager-Signature"
+
+// Formats are the supported formats of payloads
+var Formats = []string{FormatJSON, FormatSlack, FormatTeams}
+
+// Message is sent to a webhook. Title and Text are used by the chat formats, Data by the JSON format
+type Message struct {
+	Title string
+	Text  string
+	Data  interface{}
+}
+
+// Sender posts messages to webhooks
+type Sender struct {
+	Client     *http.Client
+	Secret     string        // signs the payloads with HMAC-SHA256, optional
+	Template   string        // text/template of the payload executed with the Message, instead of the format
+	Attempts   int           // deliveries attempted before giving up
+	Backoff    time.Duration // wait before the first retry, doubled on each retry
+	MaxBackoff time.Duration // longest wait between retries
+}
+
+// NewSender returns a Sender signing with secret and retrying failed deliveries 3 times
+func NewSender(secret string) *Sender {
+	return &Sender{
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Secret:     secret,
+		Attempts:   4,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// CheckFormat returns an error if format is not supported
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if format == f {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown webhook format %q, use one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Payload renders msg in format, or with the template of the sender if it has one
+func (s *Sender) Payload(format string, msg Message) ([]byte, error) {
+	if s.Template != "" {
+		tmpl, err := template.New("payload").Funcs(template.FuncMap{"json": toJSON}).Parse(s.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid payload template")
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, msg); err != nil {
+			return nil, errors.Wrap(err, "can't render payload template")
+		}
+		return buf.Bytes(), nil
+	}
+
+	var payload interface{}
+	switch format {
+	case FormatJSON, "":
+		payload = msg.Data
+	case FormatSlack:
+		payload = map[string]interface{}{
+			"text": fmt.Sprintf("*%s*\n%s", msg.Title, msg.Text),
+		}
+	case FormatTeams:
+		payload = map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  msg.Title,
+			"title":    msg.Title,
+			"text":     strings.Replace(msg.Text, "\n", "\n\n", -1),
+		}
+	default:
+		return nil, CheckFormat(format)
+	}
+	return json.Marshal(payload)
+}
+
+// Send posts msg in format to the webhook at target. Network errors, 429 and 5xx responses are retried until ctx
+// is done
+func (s *Sender) Send(ctx context.Context, target, format string, msg Message) error {
+	body, err := s.Payload(format, msg)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, target, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.Attempts {
+			return errors.Wrapf(err, "can't deliver webhook to %s after %d attempt(s)", redact(target), attempt)
+		}
+		if s.MaxBackoff > 0 && backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+		log.Warnf("Webhook delivery to %s failed, retrying in %s: %s", redact(target), backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "can't deliver webhook to %s after %d attempt(s)", redact(target), attempt)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// post posts body to target once. It returns true if a failed delivery can be retried
+func (s *Sender) post(ctx context.Context, target string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "invalid webhook URL")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tagmanager")
+	if s.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.Secret, body))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if uerr, ok := err.(*url.Error); ok {
+			uerr.URL = redact(target)
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, errors.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, errors.Errorf("webhook returned %s", resp.Status)
+}
+
+// Sign returns the signature of body with secret, as sent in SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func toJSON(v interface{}) (string, error) {
+	dat, err := json.Marshal(v)
+	return string(dat), err
+}
+
+// redact removes the path and the query of target, which often hold the secret of webhooks
+func redact(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host
+}
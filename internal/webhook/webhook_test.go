@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSender_Payload(t *testing.T) {
+	msg := Message{Title: "Title", Text: "line 1\nline 2", Data: map[string]string{"event": "run"}}
+	tests := []struct {
+		name     string
+		format   string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{name: "json", format: FormatJSON, want: `{"event":"run"}`},
+		{name: "slack", format: FormatSlack, want: `{"text":"*Title*\nline 1\nline 2"}`},
+		{name: "teams", format: FormatTeams, want: `{"@context":"https://schema.org/extensions","@type":"MessageCard","summary":"Title","text":"line 1\n\nline 2","title":"Title"}`},
+		{name: "template", template: `{"summary": {{json .Title}}, "event": {{json .Data.event}}}`, want: `{"summary": "Title", "event": "run"}`},
+		{name: "unknown format", format: "email", wantErr: true},
+		{name: "invalid template", template: `{{.Title`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSender("")
+			s.Template = tt.template
+			got, err := s.Payload(tt.format, msg)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestSender_Send(t *testing.T) {
+	var (
+		calls     int
+		body      []byte
+		signature string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if calls < 3 || r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	s := NewSender("secret")
+	s.Backoff = time.Millisecond
+	err := s.Send(context.Background(), server.URL+"/hook", FormatJSON, Message{Data: map[string]string{"event": "run"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls, "503 responses are retried")
+	assert.Equal(t, Sign("secret", body), signature)
+	var data map[string]string
+	assert.Nil(t, json.Unmarshal(body, &data))
+
+	calls = 0
+	err = s.Send(context.Background(), server.URL+"/broken", FormatJSON, Message{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls, "4xx responses are not retried")
+
+	calls = 0
+	s.Attempts = 2
+	err = s.Send(context.Background(), server.URL+"/hook", FormatJSON, Message{})
+	assert.EqualError(t, err, "can't deliver webhook to "+server.URL+" after 2 attempt(s): webhook returned 503 Service Unavailable")
+
+	calls = 0
+	s.Attempts, s.Backoff, s.MaxBackoff = 3, time.Hour, time.Millisecond
+	err = s.Send(context.Background(), server.URL+"/down", FormatJSON, Message{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, calls, "waits are bounded by MaxBackoff")
+
+	calls = 0
+	s.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Send(ctx, server.URL+"/down", FormatJSON, Message{})
+	assert.EqualError(t, err, "can't deliver webhook to "+server.URL+" after 1 attempt(s): context deadline exceeded")
+	assert.Equal(t, 1, calls, "retries stop when the context is done")
+}
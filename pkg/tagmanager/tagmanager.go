@@ -0,0 +1,59 @@
+// Package tagmanager exposes the extension points of the tag manager to programs embedding it. Custom types of
+// conditions and actions are registered before the commands run:
+//
+//	func main() {
+//		err := tagmanager.RegisterCondition(tagmanager.Spec{
+//			Type:   "nameHasPrefix",
+//			Params: []tagmanager.Param{{Name: "prefix", Type: tagmanager.StringParam, Required: true}},
+//		}, func(p tagmanager.ConditionItem, data *tagmanager.Resource) bool {
+//			return data.Name != nil && strings.HasPrefix(*data.Name, p.GetString("prefix"))
+//		})
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		commands.Execute()
+//	}
+package tagmanager
+
+import (
+	"github.com/nordcloud/azure-tag-manager/internal/azure"
+	"github.com/nordcloud/azure-tag-manager/internal/azure/rules"
+)
+
+type (
+	// Resource is the resource conditions and actions are evaluated on
+	Resource = azure.Resource
+	// TagChanges are the changes of tags returned by actions
+	TagChanges = azure.TagChanges
+	// ConditionItem is a condition of a rule, with its parameters
+	ConditionItem = rules.ConditionItem
+	// ActionItem is an action of a rule, with its parameters
+	ActionItem = rules.ActionItem
+	// Spec describes a type of conditions or actions and its parameters
+	Spec = rules.Spec
+	// Param describes a parameter of a condition or an action
+	Param = rules.Param
+	// ParamType is the type of a parameter
+	ParamType = rules.ParamType
+	// ConditionFunc evaluates a condition on a resource
+	ConditionFunc = azure.ConditionFunc
+	// ActionFunc computes the tag changes of an action on a resource
+	ActionFunc = azure.ActionFunc
+)
+
+// Types of parameters
+const (
+	StringParam     = rules.StringParam
+	StringListParam = rules.StringListParam
+	StringMapParam  = rules.StringMapParam
+)
+
+// RegisterCondition adds a custom type of conditions, see azure.RegisterCondition
+func RegisterCondition(spec Spec, eval ConditionFunc) error {
+	return azure.RegisterCondition(spec, eval)
+}
+
+// RegisterAction adds a custom type of actions, see azure.RegisterAction
+func RegisterAction(spec Spec, execute ActionFunc) error {
+	return azure.RegisterAction(spec, execute)
+}